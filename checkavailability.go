@@ -1,71 +1,164 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"io"
 	"io/ioutil"
-	"net"
 	"net/http"
-	"sync/atomic"
+	"net/http/httptrace"
 	"time"
 )
 
-func checkAvailability(url string) (uint64, time.Duration) {
-	var i, index uint64
-	countRequest := atomic.LoadUint64(&CountRequest)
-	timeOutRequest := time.Millisecond * time.Duration(atomic.LoadUint64(&TimeOutRequest))
-	timeResponse := time.Millisecond * 0
+// probeSettings параметры проверки одного сайта
+type probeSettings struct {
+	CountRequest   uint64        // количество параллельных запросов к сайту
+	TimeOutRequest time.Duration // таймаут одиночного запроса
+	Mode           TransportMode // режим соединений, пустой - TransportMode из config.yaml
+}
+
+// defaultProbeSettings параметры проверки из config.yaml
+func defaultProbeSettings() probeSettings {
+	return probeSettings{
+		CountRequest:   currentConfig().CountRequest,
+		TimeOutRequest: currentConfig().timeOutRequest(),
+	}
+}
 
-	ch := make(chan time.Duration)
+// checkAvailability отправляет CountRequest параллельных запросов к сайту,
+// отмена ctx прерывает запросы, не дожидаясь TimeOutRequest
+func checkAvailability(ctx context.Context, url string, settings probeSettings) ResponseData {
+	var i uint64
+	countRequest := settings.CountRequest
+	timeOutRequest := settings.TimeOutRequest
+	transport, mode := probeTransport(settings.Mode)
+	ctx, sp := startSpan(ctx, "checkAvailability", spanInternal, "url", url, "count", countRequest, "mode", mode)
+	defer sp.finish()
+	client := &http.Client{Transport: transport, CheckRedirect: checkTargetRedirect}
+	data := ResponseData{Outcomes: make(map[ProbeOutcome]uint64), Checked: true, Mode: mode}
+
+	host := hostLabel(url)
+	// запрещенный домен или адрес не запрашивается, все запросы получают класс blocked
+	if err := currentTargetPolicy().checkURL(url); err != nil {
+		data.Outcomes[OutcomeBlocked] = countRequest
+		data.Error = err.Error()
+		probeResults.WithLabelValues(host, string(OutcomeBlocked)).Add(float64(countRequest))
+		sp.fail(err)
+		return data
+	}
+	ch := make(chan ProbeResult)
+	probes := make([]probeTimings, 0, countRequest)
 
 	for i = 0; i < countRequest; i++ {
-		go readUrl(url, timeOutRequest, ch)
+		go readUrl(ctx, client, url, timeOutRequest, ch)
 	}
 
 	for i = 0; i < countRequest; i++ {
-		t := <-ch
-		if t == time.Second*9999 {
-			if index == 0 {
-				index = i
+		p := <-ch
+		observeProbe(host, p)
+		data.Outcomes[p.Outcome]++
+		if p.Reused {
+			data.ReusedConns++
+		}
+		if !p.OK() {
+			if data.Error == "" {
+				data.Error = p.Error
 			}
 			continue
 		}
-		if t > timeResponse {
-			timeResponse = t
+		data.ResponseCount++
+		probes = append(probes, p.Timings)
+		if p.Duration > data.TimeResponse {
+			data.TimeResponse = p.Duration
 		}
 	}
-	if index == 0 {
-		return i, timeResponse
+	data.Latency = phaseLatency(probes)
+	sp.set("responses", data.ResponseCount, "time_response", data.TimeResponse)
+	if data.ResponseCount == 0 && data.Error != "" {
+		sp.fail(errors.New(data.Error))
 	}
-	return index, timeResponse
+	return data
 }
 
-func readUrl(url string, sec time.Duration, ch chan time.Duration) {
-	var defaultTtransport http.RoundTripper = &http.Transport{
-		Dial: (&net.Dialer{
-			Timeout:   sec,
-			KeepAlive: sec}).Dial,
-		TLSHandshakeTimeout: sec}
-	client := &http.Client{Transport: defaultTtransport}
-	start := time.Now()
-	resp, err := client.Get(url)
+// readUrl один запрос к сайту, sec - таймаут запроса вместе с чтением тела.
+// Запрос ждет разрешения ограничителя RateLimit; ответ 429 или 503 с Retry-After откладывает запросы к хосту
+// и повторяется один раз, если пауза успевает до срока parent. Запрос, прерванный отменой parent, получает класс cancelled.
+func readUrl(parent context.Context, client *http.Client, url string, sec time.Duration, ch chan ProbeResult) {
+	parent, sp := startSpan(parent, "readUrl", spanClient, "http.url", url)
+	defer sp.finish()
+	host := limitHost(url)
+	var res ProbeResult
+	for attempt := 0; ; attempt++ {
+		waited, err := probeLimits.wait(parent, host)
+		if err != nil {
+			res = ProbeResult{Outcome: probeErrorOutcome(parent, err), Error: err.Error(), Waited: waited}
+			break
+		}
+		var pause time.Duration
+		res, pause = probeOnce(parent, client, url, sec)
+		res.Waited = waited
+		if pause = probeLimits.delay(host, pause); pause == 0 || attempt > 0 {
+			break
+		}
+		// один повтор после Retry-After, если он успевает до срока запроса
+		if deadline, ok := parent.Deadline(); ok && time.Now().Add(pause).After(deadline) {
+			break
+		}
+	}
+	recordProbe(parent, url, res)
+	ch <- res
+}
 
+// probeOnce отправляет запрос и читает тело ответа, pause - Retry-After ответа 429 или 503
+func probeOnce(parent context.Context, client *http.Client, url string, sec time.Duration) (ProbeResult, time.Duration) {
+	ctx, cancel := context.WithTimeout(parent, sec)
+	defer cancel()
+	tracer := &phaseTracer{}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, tracer.clientTrace()), http.MethodGet, url, nil)
 	if err != nil {
-		ch <- time.Second * 9999
-		//fmt.Println("ошибка client.Get(", url, ") ", err)
-		return
+		return ProbeResult{Outcome: OutcomeError, Error: err.Error()}, 0
 	}
-	if resp.StatusCode == 429 { //слишком много запросов
-		ch <- time.Second * 9999
-		//fmt.Println("Слишком много запросов", url)
-		return
+
+	start := time.Now()
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return ProbeResult{Outcome: probeErrorOutcome(parent, err), Error: err.Error(), Duration: time.Since(start)}, 0
 	}
 	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	_ = body
+	n, err := io.Copy(ioutil.Discard, resp.Body)
+	end := time.Now()
+	res := ProbeResult{
+		StatusCode: resp.StatusCode,
+		Outcome:    statusOutcome(resp.StatusCode),
+		Duration:   end.Sub(start),
+		BytesRead:  n,
+		Timings:    tracer.timings(start, end),
+		Reused:     tracer.reused(),
+	}
 	if err != nil {
-		ch <- time.Second * 9999
-		//fmt.Println("ошибка ioutil.ReadAll()", err)
+		res.Outcome = OutcomeBodyError
+		if parent.Err() != nil {
+			res.Outcome = OutcomeCancelled
+		}
+		res.Error = err.Error()
+	} else if !res.OK() {
+		res.Error = resp.Status
+	}
+	return res, retryAfter(resp, end)
+}
+
+// recordProbe запись уровня debug о каждом запросе к сайту и атрибуты спана readUrl
+func recordProbe(ctx context.Context, url string, p ProbeResult) {
+	if sp := spanFromContext(ctx); sp != nil {
+		sp.set("outcome", p.Outcome, "http.status_code", p.StatusCode, "reused", p.Reused, "rate_limit.wait", p.Waited)
+		if !p.OK() {
+			sp.fail(errors.New(p.Error))
+		}
+	}
+	if !logEnabled(levelDebug) {
 		return
 	}
-	end := time.Now()
-	ch <- end.Sub(start)
+	logDebug(ctx, "Запрос к сайту", "url", url, "outcome", p.Outcome, "status", p.StatusCode,
+		"duration", p.Duration, "waited", p.Waited, "bytes", p.BytesRead, "reused", p.Reused, "error", p.Error)
 }
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	maxCheckURLs    = 500 // максимум адресов в одной проверке
+	maxCountRequest = 100 // максимальное количество запросов к одному сайту
+)
+
+// checkSpec задание на проверку: поисковый запрос либо список адресов
+type checkSpec struct {
+	Search    string        `json:"search,omitempty"`    // строка поиска
+	Provider  string        `json:"provider,omitempty"`  // провайдер поиска, по умолчанию SearchProvider
+	Urls      []string      `json:"urls,omitempty"`      // адреса для проверки без поиска
+	Count     uint64        `json:"count,omitempty"`     // количество запросов к сайту, по умолчанию CountRequest
+	TimeOut   uint64        `json:"timeout,omitempty"`   // таймаут одиночного запроса в миллисекундах, по умолчанию TimeOutRequest
+	Workers   int           `json:"workers,omitempty"`   // одновременно проверяемых сайтов, по умолчанию RequestCheckWorkers
+	Group     string        `json:"group,omitempty"`     // группировка результатов: domain, host или url
+	Mode      TransportMode `json:"mode,omitempty"`      // режим соединений cold или warm, по умолчанию TransportMode
+	AllBlocks bool          `json:"allblocks,omitempty"` // проверять сайты рекламы и колдунщиков, по умолчанию только обычные результаты
+	Monitor   string        `json:"-"`                   // монитор, запустивший проверку
+	SearchOptions
+}
+
+// checkProgress необязательные обработчики хода проверки
+type checkProgress struct {
+	Sites  func(items []responseItem)           // список сайтов, которые будут проверены
+	Result func(host string, data ResponseData) // результат проверки сайта
+}
+
+// validate проверяет задание по конфигурации cfg и подставляет значения по умолчанию
+func (spec *checkSpec) validate(cfg *Config) error {
+	if (spec.Search == "") == (len(spec.Urls) == 0) {
+		return fmt.Errorf("either search or urls must be set")
+	}
+	if spec.Search != "" {
+		name := spec.Provider
+		if name == "" {
+			name = cfg.SearchProvider
+		}
+		p, err := getSearchProvider(name)
+		if err != nil {
+			return err
+		}
+		spec.Provider = p.Name()
+		if err := spec.SearchOptions.validate(); err != nil {
+			return err
+		}
+	}
+	if len(spec.Urls) > maxCheckURLs {
+		return fmt.Errorf("too many urls: %d, max %d", len(spec.Urls), maxCheckURLs)
+	}
+	for _, s := range spec.Urls {
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("bad url %q", s)
+		}
+	}
+	if spec.Count > maxCountRequest {
+		return fmt.Errorf("count %d is more than %d", spec.Count, maxCountRequest)
+	}
+	if timeOutWork := cfg.timeOutWork(); spec.TimeOut > uint64(timeOutWork/time.Millisecond) {
+		return fmt.Errorf("timeout %dms is more than TimeOutWork %v", spec.TimeOut, timeOutWork)
+	}
+	if _, ok := siteGroups[spec.Group]; !ok {
+		return fmt.Errorf("bad group value %q, expected domain, host or url", spec.Group)
+	}
+	if err := spec.Mode.validate(); err != nil {
+		return err
+	}
+	if spec.Workers < 0 {
+		return fmt.Errorf("bad workers value %d", spec.Workers)
+	}
+	if max := int(cfg.CheckWorkers); spec.Workers > max {
+		spec.Workers = max
+	}
+	return nil
+}
+
+func (spec *checkSpec) settings() probeSettings {
+	settings := defaultProbeSettings()
+	settings.Mode = spec.Mode
+	if spec.Count != 0 {
+		settings.CountRequest = spec.Count
+	}
+	if spec.TimeOut != 0 {
+		settings.TimeOutRequest = time.Millisecond * time.Duration(spec.TimeOut)
+	}
+	return settings
+}
+
+// executeCheck выполняет проверенное validate задание, общая часть синхронных запросов и фоновых заданий
+func executeCheck(ctx context.Context, spec checkSpec, progress checkProgress) (map[string]ResponseData, error) {
+	start := time.Now()
+	var items []responseItem
+	if spec.Search != "" {
+		var err error
+		if items, err = searchItems(ctx, spec.Provider, spec.Search, spec.SearchOptions); err != nil {
+			return nil, err
+		}
+	} else {
+		for _, u := range spec.Urls {
+			items = append(items, responseItem{Host: u, Url: u})
+		}
+	}
+	if spec.Group != "" {
+		for i := range items {
+			items[i].Host = siteKey(items[i].Url, spec.Group)
+		}
+	}
+	// результаты выдачи по каждому сайту добавляются к результату проверки
+	serp := make(map[string][]SerpItem)
+	for _, item := range items {
+		if item.Type != "" {
+			serp[item.Host] = append(serp[item.Host], item.serpItem())
+		}
+	}
+	onResult := progress.Result
+	if onResult != nil && len(serp) > 0 {
+		onResult = func(host string, data ResponseData) {
+			data.Serp = serp[host]
+			progress.Result(host, data)
+		}
+	}
+
+	if !spec.AllBlocks {
+		items = organicItems(items)
+	}
+	items = uniqueSites(items)
+	if progress.Sites != nil {
+		progress.Sites(items)
+	}
+	settings := spec.settings()
+	s := checkSites(ctx, items, spec.Workers, settings, onResult)
+	for host, data := range s {
+		if items, ok := serp[host]; ok {
+			data.Serp = items
+			s[host] = data
+		}
+	}
+	timeOutWork := currentConfig().timeOutWork()
+	saveHistory(spec, settings, timeOutWork, start, s)
+	return s, nil
+}
+
+// organicItems обычные результаты выдачи и адреса без поиска. Реклама и колдунщики остаются в Serp
+// проверяемых сайтов, но сами сайты из них не проверяются.
+func organicItems(items []responseItem) []responseItem {
+	res := make([]responseItem, 0, len(items))
+	for _, item := range items {
+		if item.Type == "" || item.Type == SerpOrganic {
+			res = append(res, item)
+		}
+	}
+	return res
+}
+
+// searchItems выдача провайдера по запросу, ошибка поиска возвращается как *SearchError
+func searchItems(ctx context.Context, providerName, query string, opts SearchOptions) ([]responseItem, error) {
+	provider, err := getSearchProvider(providerName)
+	if err != nil {
+		return nil, err
+	}
+	searchRequests.WithLabelValues(provider.Name()).Inc()
+	res := provider.Search(ctx, query, opts)
+	if res.Error != nil {
+		searchErrors.WithLabelValues(provider.Name()).Inc()
+		logWarn(ctx, "Ошибка поиска", "provider", provider.Name(), "error", res.Error)
+		var se *SearchError
+		if !errors.As(res.Error, &se) {
+			se = &SearchError{Provider: provider.Name(), Kind: SerpFetchError, Err: res.Error}
+		}
+		return nil, se
+	}
+	return res.Items, nil
+}
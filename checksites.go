@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// глобальный лимит одновременных проверок сайтов для всех запросов,
+// при изменении CheckWorkers в config.yaml семафор пересоздается
+var (
+	checkSemaphoreMu sync.Mutex
+	checkSemaphore   chan struct{}
+)
+
+func setCheckWorkers(n uint64) {
+	checkSemaphoreMu.Lock()
+	defer checkSemaphoreMu.Unlock()
+	if checkSemaphore != nil && uint64(cap(checkSemaphore)) == n {
+		return
+	}
+	checkSemaphore = make(chan struct{}, n)
+}
+
+// acquireCheckSlot ожидает свободный слот глобального пула, возвращает функцию освобождения
+func acquireCheckSlot(ctx context.Context) (func(), bool) {
+	checkSemaphoreMu.Lock()
+	sem := checkSemaphore
+	checkSemaphoreMu.Unlock()
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+type checkResult struct {
+	host string
+	data ResponseData
+}
+
+// uniqueSites оставляет по одному адресу на каждый сайт
+func uniqueSites(items []responseItem) []responseItem {
+	seen := make(map[string]struct{}, len(items))
+	unique := make([]responseItem, 0, len(items))
+	for _, item := range items {
+		if _, ok := seen[item.Host]; ok {
+			continue
+		}
+		seen[item.Host] = struct{}{}
+		unique = append(unique, item)
+	}
+	return unique
+}
+
+// checkSites проверяет доступность сайтов параллельно, не более workers одновременно.
+// Сайты, не проверенные до истечения ctx, возвращаются с Checked == false.
+// onResult, если задан, вызывается для каждого сайта по мере получения результата.
+func checkSites(ctx context.Context, items []responseItem, workers int, settings probeSettings, onResult func(host string, data ResponseData)) map[string]ResponseData {
+	queue := uniqueSites(items)
+	s := make(map[string]ResponseData, len(queue))
+	for _, item := range queue {
+		s[item.Host] = ResponseData{}
+	}
+	if len(queue) == 0 {
+		return s
+	}
+	if workers <= 0 {
+		workers = int(currentConfig().RequestCheckWorkers)
+	}
+	if workers > len(queue) {
+		workers = len(queue)
+	}
+
+	jobs := make(chan responseItem)
+	results := make(chan checkResult, len(queue))
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for item := range jobs {
+				release, ok := acquireCheckSlot(ctx)
+				if !ok {
+					continue
+				}
+				checksInFlight.Inc()
+				data := checkAvailability(ctx, item.Url, settings)
+				checksInFlight.Dec()
+				release()
+				results <- checkResult{item.Host, data}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, item := range queue {
+			select {
+			case jobs <- item:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	merge := func(r checkResult) {
+		s[r.host] = r.data
+		if onResult != nil {
+			onResult(r.host, r.data)
+		}
+	}
+	for done := 0; done < len(queue); done++ {
+		select {
+		case r := <-results:
+			merge(r)
+		case <-ctx.Done():
+			// дождаться прерванных проверок: завершенные до отмены запросы возвращаются частичным результатом,
+			// отмененные учитываются в Outcomes как cancelled; сайт без единого завершенного запроса не проверен
+			wg.Wait()
+			close(results)
+			var cancelled uint64
+			for r := range results {
+				if n := r.data.Outcomes[OutcomeCancelled]; n > 0 {
+					cancelled += n
+					if n == r.data.total() {
+						r.data.Checked = false
+					}
+				}
+				merge(r)
+			}
+			if cancelled > 0 {
+				logInfo(ctx, "Отменены запросы к сайтам", "cancelled", cancelled, "reason", ctx.Err())
+			}
+			return s
+		}
+	}
+	return s
+}
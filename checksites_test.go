@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// при отмене сайт возвращается с результатами завершенных запросов и отмененными запросами в Outcomes
+func TestCheckSitesPartialOnCancel(t *testing.T) {
+	setTestConfig(t, func(cfg *Config) {
+		cfg.TargetPolicy.AllowPrivate = true
+		cfg.RateLimit = RateLimit{}
+	})
+	var requests int32
+	release := make(chan struct{})
+	defer close(release)
+	// первый запрос к сайту partial отвечает сразу, остальные - после отмены проверки
+	partial := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) > 1 {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+	}))
+	defer partial.Close()
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer hanging.Close()
+
+	items := []responseItem{
+		{Host: "partial", Url: partial.URL + "/"},
+		{Host: "hanging", Url: hanging.URL + "/"},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	settings := probeSettings{CountRequest: 3, TimeOutRequest: 5 * time.Second, Mode: TransportCold}
+	s := checkSites(ctx, items, 2, settings, nil)
+
+	p := s["partial"]
+	if !p.Checked || p.ResponseCount != 1 || p.Outcomes[OutcomeOK] != 1 || p.Outcomes[OutcomeCancelled] != 2 {
+		t.Errorf("partial: got %+v, want 1 ok and 2 cancelled requests", p)
+	}
+	h, ok := s["hanging"]
+	if !ok || h.Checked || h.Outcomes[OutcomeCancelled] != 3 {
+		t.Errorf("hanging: got %+v, want not checked with 3 cancelled requests", h)
+	}
+}
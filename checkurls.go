@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxCheckBodySize = 1 << 20 // максимальный размер тела запроса /check
+
+// checkURLs проверяет доступность переданного списка адресов без поиска.
+// Тело запроса: JSON объект checkSpec, JSON массив адресов или адреса по одному в строке,
+// count, timeout, workers, group и mode можно также передать параметрами запроса.
+func checkURLs(w http.ResponseWriter, r *http.Request) {
+	timeOutWork := currentConfig().timeOutWork()
+	start := time.Now()
+	defer func() {
+		logInfo(r.Context(), "Время выполнения запроса", "handler", "/check", "duration", time.Since(start))
+	}()
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(405), 405)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxCheckBodySize))
+	if err != nil {
+		http.Error(w, http.StatusText(413), 413)
+		return
+	}
+	spec, err := parseCheckRequest(r, body)
+	if err == nil && spec.Search != "" {
+		err = fmt.Errorf("search is not allowed in /check")
+	}
+	if err == nil {
+		err = spec.validate(currentConfig())
+	}
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeOutWork)
+	defer cancel()
+	s, err := executeCheck(ctx, spec, checkProgress{})
+	if err != nil {
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	if r.Context().Err() != nil {
+		logInfo(r.Context(), "Клиент отключился, запрос прерван", "handler", "/check")
+		return
+	}
+	if ctx.Err() != nil {
+		logWarn(r.Context(), "Истекло время выполнения запроса", "handler", "/check", "timeout", timeOutWork)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+func parseCheckRequest(r *http.Request, body []byte) (checkSpec, error) {
+	var spec checkSpec
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case len(trimmed) > 0 && trimmed[0] == '{':
+		if err := json.Unmarshal(trimmed, &spec); err != nil {
+			return spec, fmt.Errorf("bad JSON body: %v", err)
+		}
+	case len(trimmed) > 0 && trimmed[0] == '[':
+		if err := json.Unmarshal(trimmed, &spec.Urls); err != nil {
+			return spec, fmt.Errorf("bad JSON body: %v", err)
+		}
+	default:
+		sc := bufio.NewScanner(bytes.NewReader(trimmed))
+		for sc.Scan() {
+			if line := strings.TrimSpace(sc.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				spec.Urls = append(spec.Urls, line)
+			}
+		}
+	}
+
+	q := r.URL.Query()
+	for name, dst := range map[string]*uint64{"count": &spec.Count, "timeout": &spec.TimeOut} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return spec, fmt.Errorf("bad %s value %q", name, v)
+			}
+			*dst = n
+		}
+	}
+	if v := q.Get("group"); v != "" {
+		spec.Group = v
+	}
+	if v := q.Get("mode"); v != "" {
+		spec.Mode = TransportMode(v)
+	}
+	if v := q.Get("workers"); v != "" {
+		n, err := parseWorkers(v)
+		if err != nil {
+			return spec, err
+		}
+		spec.Workers = n
+	}
+	return spec, nil
+}
@@ -1,17 +1,24 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
 	"html/template"
 	"io/ioutil"
-	"net"
 	"net/http"
-	"sync/atomic"
+	"net/url"
+	"path/filepath"
 	"time"
 )
 
+// searchParams параметры /sitesclient, передаваемые в /sites
+var searchParams = []string{"provider", "group", "mode", "pages", "hosts", "region", "lang", "family", "allblocks"}
+
 func clientSearchSites(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	defer func() {
+		logInfo(r.Context(), "Время выполнения запроса", "handler", "/sitesclient", "duration", time.Since(start))
+	}()
 
 	if r.Method != http.MethodGet {
 		w.Header().Set("Allow", http.MethodGet)
@@ -26,21 +33,56 @@ func clientSearchSites(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var sec = time.Millisecond * time.Duration(atomic.LoadUint64(&TimeOutRequest))
-	var defaultTtransport http.RoundTripper = &http.Transport{
-		Dial: (&net.Dialer{
-			Timeout:   sec,
-			KeepAlive: sec}).Dial,
-		TLSHandshakeTimeout: sec}
-	client := &http.Client{Transport: defaultTtransport}
+	// по умолчанию страница отдается сразу, а строки таблицы добавляются из /sites/stream,
+	// live=0 - дождаться полного результата и сформировать таблицу на сервере
+	if r.URL.Query().Get("live") != "0" {
+		q := url.Values{"search": {search}}
+		for _, name := range searchParams {
+			if v := r.URL.Query().Get(name); v != "" {
+				q.Set(name, v)
+			}
+		}
+		renderClientPage(r.Context(), w, ClientData{Title: search, StreamURL: "/sites/stream?" + q.Encode()})
+		return
+	}
 
-	resp, err := client.Get(ClientSearchPoint + search)
+	client := &http.Client{Transport: pooledTransport()}
 
+	// ClientSearchPoint может оканчиваться на ?search= или быть адресом /sites без параметров
+	u, err := url.Parse(currentConfig().ClientSearchPoint)
+	if err != nil {
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	q := u.Query()
+	q.Set("search", search)
+	for _, name := range searchParams {
+		if v := r.URL.Query().Get(name); v != "" {
+			q.Set(name, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	point := u.String()
+	ctx, sp := startSpan(r.Context(), "ClientSearchPoint", spanClient, "http.url", point)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, point, nil)
 	if err != nil {
+		sp.finish()
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	req.Header.Set(requestIDHeader, requestID(ctx))
+	injectTraceparent(ctx, req.Header)
+	resp, err := client.Do(req)
+
+	if err != nil {
+		sp.fail(err)
+		sp.finish()
 		http.Error(w, http.StatusText(500), 500)
 		return
 	}
 	defer resp.Body.Close()
+	sp.set("http.status_code", resp.StatusCode)
+	sp.finish()
 
 	body, err := ioutil.ReadAll(resp.Body)
 
@@ -48,6 +90,12 @@ func clientSearchSites(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, http.StatusText(500), 500)
 		return
 	}
+	// ошибка поиска (капча, неизвестная разметка) передается клиенту с тем же кодом
+	var se searchErrorBody
+	if resp.StatusCode != 200 && json.Unmarshal(body, &se) == nil && se.Error != "" {
+		http.Error(w, se.Message, resp.StatusCode)
+		return
+	}
 	//декодировать ответ и сформировать страничку ответа в структурированном виде
 	var s map[string]ResponseData
 	err = json.Unmarshal(body, &s)
@@ -58,10 +106,39 @@ func clientSearchSites(w http.ResponseWriter, r *http.Request) {
 
 	data := ClientData{Title: search,
 		Data: s}
-	tmpl, _ := template.ParseFiles("/opt/demo-service/view/search.html")
-	err = tmpl.Execute(w, &data)
+	renderClientPage(r.Context(), w, data)
+}
+
+func renderClientPage(ctx context.Context, w http.ResponseWriter, data ClientData) {
+	renderTemplate(ctx, w, "search.html", &data)
+}
+
+// шаблоны страниц из TemplateDir
+var pageTemplates = []string{"search.html", "ranks.html"}
+
+func init() {
+	registerReadinessCheck("templates", func() error {
+		dir := currentConfig().TemplateDir
+		for _, name := range pageTemplates {
+			if _, err := template.ParseFiles(filepath.Join(dir, name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// renderTemplate выводит страницу по шаблону name из TemplateDir
+func renderTemplate(ctx context.Context, w http.ResponseWriter, name string, data interface{}) {
+	ctx, sp := startSpan(ctx, "renderTemplate", spanInternal, "template", name)
+	defer sp.finish()
+	tmpl, err := template.ParseFiles(filepath.Join(currentConfig().TemplateDir, name))
+	if err == nil {
+		err = tmpl.Execute(w, data)
+	}
 	if err != nil {
-		fmt.Println("Ошибка парсинга шаблона", err)
+		sp.fail(err)
+		logError(ctx, "Ошибка парсинга шаблона", "template", name, "error", err)
 		http.Error(w, http.StatusText(500), 500)
 	}
 }
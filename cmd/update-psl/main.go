@@ -0,0 +1,87 @@
+// update-psl обновляет встроенную в сервис копию Public Suffix List из локального файла.
+//
+//	go run ./cmd/update-psl -from /usr/share/publicsuffix/public_suffix_list.dat
+//
+// Файл проверяется на наличие разделов ICANN и PRIVATE, после чего копируется в -to.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const minRules = 1000 // в полном списке несколько тысяч правил
+
+func main() {
+	from := flag.String("from", "", "локальный файл public_suffix_list.dat")
+	to := flag.String("to", "public_suffix_list.dat", "встраиваемая копия списка")
+	flag.Parse()
+	if *from == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	data, err := ioutil.ReadFile(*from)
+	if err != nil {
+		log.Fatal(err)
+	}
+	icann, private, err := countRules(data)
+	if err != nil {
+		log.Fatalf("%s: %v", *from, err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(*to), ".psl-*")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		log.Fatal(err)
+	}
+	if err := tmp.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.Rename(tmp.Name(), *to); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s обновлен: правил ICANN %d, PRIVATE %d\n", *to, icann, private)
+}
+
+// countRules проверяет формат списка и считает правила в разделах ICANN и PRIVATE
+func countRules(data []byte) (icann, private int, err error) {
+	var section string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case strings.Contains(line, "===BEGIN ICANN DOMAINS==="):
+			section = "icann"
+		case strings.Contains(line, "===BEGIN PRIVATE DOMAINS==="):
+			section = "private"
+		case strings.Contains(line, "===END "):
+			section = ""
+		case line == "" || strings.HasPrefix(line, "//"):
+		case section == "icann":
+			icann++
+		case section == "private":
+			private++
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return 0, 0, err
+	}
+	if icann < minRules || private == 0 {
+		return icann, private, fmt.Errorf("not a public suffix list: %d ICANN and %d PRIVATE rules", icann, private)
+	}
+	return icann, private, nil
+}
@@ -1,86 +1,379 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 )
 
-var TimeOutRequest uint64
-var TimeOutWork uint64
-var CountRequest uint64
-var ClientSearchPoint string
-
-func loadConfig() {
-	err0 := "Ошибка чтения конфигурационного файла: %w \n"
-	err1 := "Ошибка в параметре TimeOutRequest"
-	err2 := "Ошибка в параметре TimeOutWork"
-	err3 := "Ошибка в параметре CountRequest"
-	err4 := "Ошибка в параметре ClientSearchPoint"
-
-	viper.SetConfigName("config") // имя конфигурационного файла без расширения
-	viper.SetConfigType("yaml")   // тип конфигурационного файла (если расширение не указано)
-	//viper.AddConfigPath("/etc/demo-service/")   // добавить путь для поиска конфигурационного файла
-	//viper.AddConfigPath("$HOME/.demo-service")  //
-	viper.AddConfigPath("/opt/demo-service")
-	viper.AddConfigPath(".")    // путь для конфигурационного файла текущая папка
-	err := viper.ReadInConfig() //
-	if err != nil {
-		panic(fmt.Errorf(err0, err))
+// Config параметры из config.yaml, имена полей совпадают с ключами файла
+type Config struct {
+	ListenAddr            string        // адрес HTTP сервера, применяется при запуске
+	TemplateDir           string        // папка шаблонов страниц
+	LogLevel              string        // уровень журнала: debug, info, warn, error
+	TraceExporter         TraceExporter // выгрузка трассировки: file, otlp, пустая строка - выключена
+	TraceFile             string        // файл спанов для TraceExporter file
+	TraceEndpoint         string        // адрес OTLP/HTTP коллектора для TraceExporter otlp
+	DrainTimeout          uint64        // время на завершение проверок при остановке в миллисекундах
+	ShutdownDelay         uint64        // задержка закрытия сервера после перехода в not ready в миллисекундах
+	TimeOutRequest        uint64        // таймаут одиночного запроса в миллисекундах
+	TimeOutWork           uint64        // таймаут полного запроса в миллисекундах
+	CountRequest          uint64        // количество запросов по одному сайту
+	ClientSearchPoint     string        // адрес /sites для /sitesclient?live=0
+	SearchProvider        string        // провайдер поиска по умолчанию
+	SearxngURL            string        // адрес JSON API SearXNG
+	ProviderCheckInterval uint64        // период проверки доступности поисковика для /readyz в миллисекундах, 0 - не проверять
+	YandexPages           int           // параметры выдачи Яндекса по умолчанию
+	YandexHosts           int
+	YandexRegion          int
+	YandexLang            string
+	YandexFamily          string
+	SerpDumpDir           string // папка для страниц выдачи, которые не удалось разобрать
+	TransportMode         TransportMode
+	MaxConnsPerHost       int
+	MaxIdleConnsPerHost   int
+	IdleConnTimeout       uint64 // в миллисекундах
+	HTTP2                 bool
+	CheckWorkers          uint64 // общий лимит одновременных проверок сайтов
+	RequestCheckWorkers   uint64 // лимит одновременных проверок сайтов в одном запросе
+	JobWorkers            uint64 // количество одновременно выполняемых фоновых заданий, применяется при запуске
+	JobQueueSize          uint64 // размер очереди фоновых заданий, применяется при запуске
+	JobRetention          uint64 // время хранения завершенных заданий в миллисекундах
+	HistoryFile           string // файл базы истории проверок, пустая строка - история не ведется, применяется при запуске
+	MetricsMaxHosts       uint64 // лимит различных значений метки host в метриках проверок
+	MonitorJitter         uint64 // случайная задержка запуска мониторов в миллисекундах по умолчанию
+	Monitors              []Monitor
+	RankTracking          RankTracking
+	TargetPolicy          TargetPolicy // ограничения адресов проверяемых сайтов
+	RateLimit             RateLimit    // ограничения частоты запросов к проверяемым сайтам
+}
+
+func (c *Config) timeOutRequest() time.Duration {
+	return time.Millisecond * time.Duration(c.TimeOutRequest)
+}
+
+func (c *Config) providerCheckInterval() time.Duration {
+	return time.Millisecond * time.Duration(c.ProviderCheckInterval)
+}
+
+func (c *Config) timeOutWork() time.Duration {
+	return time.Millisecond * time.Duration(c.TimeOutWork)
+}
+
+// yandexDefaults параметры выдачи Яндекса YandexPages, YandexHosts, YandexRegion, YandexLang, YandexFamily
+func (c *Config) yandexDefaults() SearchOptions {
+	return SearchOptions{Pages: c.YandexPages, Hosts: c.YandexHosts, Region: c.YandexRegion, Lang: c.YandexLang, Family: c.YandexFamily}
+}
+
+// transport настройки общего транспорта, таймаут соединения - TimeOutRequest
+func (c *Config) transport() transportSettings {
+	return transportSettings{
+		Mode:                c.TransportMode,
+		DialTimeout:         c.timeOutRequest(),
+		MaxConnsPerHost:     c.MaxConnsPerHost,
+		MaxIdleConnsPerHost: c.MaxIdleConnsPerHost,
+		IdleConnTimeout:     time.Millisecond * time.Duration(c.IdleConnTimeout),
+		HTTP2:               c.HTTP2,
 	}
+}
+
+// tracing настройки трассировки
+func (c *Config) tracing() traceSettings {
+	return traceSettings{Exporter: c.TraceExporter, File: c.TraceFile, Endpoint: c.TraceEndpoint}
+}
 
-	p1, ok := viper.Get("TimeOutRequest").(int)
-	if !ok {
-		panic(fmt.Errorf(err1))
+func (c *Config) validate() error {
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+	switch {
+	case c.ListenAddr == "":
+		return fmt.Errorf("ListenAddr is required")
+	case c.TemplateDir == "":
+		return fmt.Errorf("TemplateDir is required")
+	case c.DrainTimeout == 0:
+		return fmt.Errorf("DrainTimeout must be positive")
+	case c.TimeOutRequest == 0:
+		return fmt.Errorf("TimeOutRequest must be positive")
+	case c.TimeOutWork == 0:
+		return fmt.Errorf("TimeOutWork must be positive")
+	case c.CountRequest == 0 || c.CountRequest > maxCountRequest:
+		return fmt.Errorf("CountRequest must be 1-%d", maxCountRequest)
+	case c.CheckWorkers == 0:
+		return fmt.Errorf("CheckWorkers must be positive")
+	case c.RequestCheckWorkers == 0:
+		return fmt.Errorf("RequestCheckWorkers must be positive")
+	case c.JobWorkers == 0:
+		return fmt.Errorf("JobWorkers must be positive")
+	case c.JobQueueSize == 0:
+		return fmt.Errorf("JobQueueSize must be positive")
+	case c.JobRetention == 0:
+		return fmt.Errorf("JobRetention must be positive")
+	case c.MaxConnsPerHost < 0:
+		return fmt.Errorf("MaxConnsPerHost must not be negative")
+	case c.MaxIdleConnsPerHost < 0:
+		return fmt.Errorf("MaxIdleConnsPerHost must not be negative")
+	}
+	if err := validateHTTPURL(c.ClientSearchPoint); err != nil {
+		return fmt.Errorf("ClientSearchPoint: %v", err)
+	}
+	if _, ok := searchProviders[strings.ToLower(c.SearchProvider)]; !ok {
+		return fmt.Errorf("SearchProvider: unknown search provider %q", c.SearchProvider)
+	}
+	if err := validateHTTPURL(c.SearxngURL); err != nil {
+		return fmt.Errorf("SearxngURL: %v", err)
 	}
-	p2, ok := viper.Get("TimeOutWork").(int)
-	if !ok {
-		panic(fmt.Errorf(err2))
+	if c.YandexPages <= 0 || c.YandexRegion <= 0 {
+		return fmt.Errorf("YandexPages and YandexRegion must be positive")
 	}
-	p3, ok := viper.Get("CountRequest").(int)
-	if !ok {
-		panic(fmt.Errorf(err3))
+	if err := c.yandexDefaults().validate(); err != nil {
+		return fmt.Errorf("Yandex: %v", err)
 	}
+	if c.TransportMode == "" {
+		return fmt.Errorf("TransportMode is required")
+	}
+	if err := c.TransportMode.validate(); err != nil {
+		return fmt.Errorf("TransportMode: %v", err)
+	}
+	if err := c.tracing().validate(); err != nil {
+		return err
+	}
+	if err := validateSchedule(0, c.MonitorJitter); err != nil {
+		return fmt.Errorf("MonitorJitter: %v", err)
+	}
+	if err := validateMonitors(c.Monitors, c); err != nil {
+		return fmt.Errorf("Monitors: %v", err)
+	}
+	if err := c.RankTracking.validate(c); err != nil {
+		return fmt.Errorf("RankTracking: %v", err)
+	}
+	if err := c.TargetPolicy.validate(); err != nil {
+		return fmt.Errorf("TargetPolicy: %v", err)
+	}
+	if err := c.RateLimit.validate(); err != nil {
+		return fmt.Errorf("RateLimit: %v", err)
+	}
+	return nil
+}
+
+func validateHTTPURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("bad url %q, expected http(s)://host/...", s)
+	}
+	return nil
+}
+
+// текущая конфигурация, заменяется целиком при успешной перезагрузке config.yaml
+var activeConfig atomic.Value // *Config
+
+// currentConfig снимок действующей конфигурации, значения внутри снимка согласованы между собой
+func currentConfig() *Config {
+	return activeConfig.Load().(*Config)
+}
 
-	p4, ok := viper.Get("ClientSearchPoint").(string)
+// состояние загрузки конфигурации для /config
+var configState struct {
+	mu          sync.Mutex
+	loadedAt    time.Time
+	hash        string // хеш действующей конфигурации для /version
+	lastError   string
+	lastErrorAt time.Time
+}
+
+func setConfigDefaults() {
+	viper.SetDefault("ListenAddr", ":8080")
+	viper.SetDefault("TemplateDir", "/opt/demo-service/view")
+	viper.SetDefault("LogLevel", "info")
+	viper.SetDefault("TraceExporter", "")
+	viper.SetDefault("TraceFile", "")
+	viper.SetDefault("TraceEndpoint", "http://127.0.0.1:4318/v1/traces")
+	viper.SetDefault("DrainTimeout", 30000)
+	viper.SetDefault("ShutdownDelay", 0)
+	viper.SetDefault("SearchProvider", "yandex")
+	viper.SetDefault("SearxngURL", "http://127.0.0.1:8888/search")
+	viper.SetDefault("ProviderCheckInterval", 60000)
+	viper.SetDefault("CheckWorkers", 20)
+	viper.SetDefault("RequestCheckWorkers", 5)
+	viper.SetDefault("JobWorkers", 2)
+	viper.SetDefault("JobQueueSize", 100)
+	viper.SetDefault("JobRetention", 3600000)
+	viper.SetDefault("HistoryFile", "")
+	viper.SetDefault("MonitorJitter", 10000)
+	viper.SetDefault("MetricsMaxHosts", 200)
+	viper.SetDefault("YandexPages", 1)
+	viper.SetDefault("YandexHosts", 0)
+	viper.SetDefault("YandexRegion", 213)
+	viper.SetDefault("YandexLang", "")
+	viper.SetDefault("YandexFamily", "")
+	viper.SetDefault("SerpDumpDir", "")
+	viper.SetDefault("TransportMode", "cold")
+	viper.SetDefault("MaxConnsPerHost", 0)
+	viper.SetDefault("MaxIdleConnsPerHost", 10)
+	viper.SetDefault("IdleConnTimeout", 90000)
+	viper.SetDefault("HTTP2", true)
+	viper.SetDefault("TargetPolicy.AllowPrivate", false)
+	viper.SetDefault("RateLimit.HostRPS", 5)
+	viper.SetDefault("RateLimit.HostBurst", 5)
+	viper.SetDefault("RateLimit.HostSpacing", 0)
+	viper.SetDefault("RateLimit.GlobalRPS", 0)
+	viper.SetDefault("RateLimit.GlobalBurst", 0)
+	viper.SetDefault("RateLimit.MaxRetryAfter", 30000)
+}
 
-	if !ok {
-		panic(fmt.Errorf(err4))
+// readConfig читает и проверяет config.yaml, ошибка не меняет действующую конфигурацию
+func readConfig() (*Config, error) {
+	if err := viper.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
 	}
-	if p4 == "" {
-		panic(fmt.Errorf(err4))
+	cfg := &Config{}
+	if err := viper.Unmarshal(cfg); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
 	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
 
-	TimeOutRequest = uint64(p1)
-	TimeOutWork = uint64(p2)
-	CountRequest = uint64(p3)
-	ClientSearchPoint = p4
+// applyConfig делает cfg действующей конфигурацией и применяет ее к работающим частям сервиса
+func applyConfig(cfg *Config) {
+	activeConfig.Store(cfg)
+	level, _ := parseLogLevel(cfg.LogLevel)
+	setLogLevel(level)
+	setCheckWorkers(cfg.CheckWorkers)
+	setTransportSettings(cfg.transport())
+	setTargetPolicy(cfg.TargetPolicy)
+	probeLimits.set(cfg.RateLimit)
+	setTraceSettings(cfg.tracing())
+	if monitors != nil {
+		monitors.setConfigMonitors(cfg.Monitors, nil)
+	}
+	if ranks != nil {
+		ranks.set(cfg.RankTracking)
+	}
+	now := time.Now()
+	hash := cfg.hash()
+	configState.mu.Lock()
+	configState.loadedAt = now
+	configState.hash = hash
+	configState.mu.Unlock()
+	configLoaded.Set(float64(now.Unix()))
+	configLastReloadOK.Set(1)
+}
 
-	viper.OnConfigChange(func(e fsnotify.Event) {
-		fmt.Println("Конфигурационный файл", e.Name, "изменен. Обновление конфигурации")
-		err := viper.ReadInConfig() //
-		if err != nil {             //
-			panic(fmt.Errorf(err0, err))
-		}
+// setupConfig настраивает источники конфигурации: файл, значения по умолчанию, переменные окружения и флаги
+func setupConfig(cl commandLine) {
+	if cl.configFile != "" {
+		viper.SetConfigFile(cl.configFile)
+	} else {
+		viper.SetConfigName("config") // имя конфигурационного файла без расширения
+		//viper.AddConfigPath("/etc/demo-service/")   // добавить путь для поиска конфигурационного файла
+		//viper.AddConfigPath("$HOME/.demo-service")  //
+		viper.AddConfigPath("/opt/demo-service")
+		viper.AddConfigPath(".") // путь для конфигурационного файла текущая папка
+	}
+	viper.SetConfigType("yaml") // тип конфигурационного файла (если расширение не указано)
+	setConfigDefaults()
+	bindOverrides(cl)
+}
 
-		p1, ok := viper.Get("TimeOutRequest").(int)
-		if !ok {
-			panic(fmt.Errorf(err1))
-		}
-		p2, ok := viper.Get("TimeOutWork").(int)
-		if !ok {
-			panic(fmt.Errorf(err2))
-		}
-		p3, ok := viper.Get("CountRequest").(int)
-		if !ok {
-			panic(fmt.Errorf(err3))
-		}
-		atomic.StoreUint64(&TimeOutRequest, uint64(p1))
-		atomic.StoreUint64(&TimeOutWork, uint64(p2))
-		atomic.StoreUint64(&CountRequest, uint64(p3))
+// loadConfig читает config.yaml при запуске и следит за его изменениями
+func loadConfig() error {
+	cfg, err := readConfig()
+	if err != nil {
+		return err
+	}
+	applyConfig(cfg)
 
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		logInfo(context.Background(), "Конфигурационный файл изменен, обновление конфигурации", "file", e.Name)
+		reloadConfig()
 	})
 	viper.WatchConfig()
+	return nil
+}
+
+// reloadConfig перечитывает config.yaml, при ошибке остается последняя корректная конфигурация
+func reloadConfig() {
+	cfg, err := readConfig()
+	if err != nil {
+		logError(context.Background(), "Ошибка в конфигурационном файле, используется прежняя конфигурация", "error", err)
+		now := time.Now()
+		configState.mu.Lock()
+		configState.lastError = err.Error()
+		configState.lastErrorAt = now
+		configState.mu.Unlock()
+		configReloads.WithLabelValues("rejected").Inc()
+		configLastReloadOK.Set(0)
+		return
+	}
+	// параметры, применяемые только при запуске, остаются прежними
+	old := currentConfig()
+	if cfg.ListenAddr != old.ListenAddr || cfg.JobWorkers != old.JobWorkers || cfg.JobQueueSize != old.JobQueueSize || cfg.HistoryFile != old.HistoryFile {
+		logWarn(context.Background(), "ListenAddr, JobWorkers, JobQueueSize и HistoryFile применяются при перезапуске сервиса")
+		cfg.ListenAddr, cfg.JobWorkers, cfg.JobQueueSize, cfg.HistoryFile = old.ListenAddr, old.JobWorkers, old.JobQueueSize, old.HistoryFile
+	}
+	configState.mu.Lock()
+	configState.lastError = ""
+	configState.lastErrorAt = time.Time{}
+	configState.mu.Unlock()
+	applyConfig(cfg)
+	configReloads.WithLabelValues("ok").Inc()
+}
+
+// hash первые 16 символов SHA-256 от JSON конфигурации
+func (c *Config) hash() string {
+	b, _ := json.Marshal(c)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+func init() {
+	registerReadinessCheck("config", func() error {
+		if activeConfig.Load() == nil {
+			return fmt.Errorf("config not loaded")
+		}
+		return nil
+	})
+}
+
+// ConfigView действующая конфигурация для GET /config
+type ConfigView struct {
+	File        string
+	LoadedAt    time.Time
+	LastError   string     `json:",omitempty"` // ошибка последней отклоненной перезагрузки
+	LastErrorAt *time.Time `json:",omitempty"`
+	Config      *Config
+}
+
+// configHandler GET /config - действующая конфигурация, время ее загрузки и ошибка последней перезагрузки
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(405), 405)
+		return
+	}
+	configState.mu.Lock()
+	v := ConfigView{File: viper.ConfigFileUsed(), LoadedAt: configState.loadedAt, LastError: configState.lastError, Config: currentConfig()}
+	if !configState.lastErrorAt.IsZero() {
+		lastErrorAt := configState.lastErrorAt
+		v.LastErrorAt = &lastErrorAt
+	}
+	configState.mu.Unlock()
+	writeJSON(w, 200, v)
 }
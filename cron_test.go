@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		expr  string
+		from  string
+		wants []string // последовательные запуски после from
+	}{
+		{"* * * * *", "2024-09-01 10:07", []string{"2024-09-01 10:08", "2024-09-01 10:09"}},
+		{"*/15 * * * *", "2024-09-01 10:07", []string{"2024-09-01 10:15", "2024-09-01 10:30", "2024-09-01 10:45", "2024-09-01 11:00"}},
+		{"5/20 * * * *", "2024-09-01 10:07", []string{"2024-09-01 10:25", "2024-09-01 10:45", "2024-09-01 11:05"}},
+		{"10-20/5 3 * * *", "2024-09-01 03:12", []string{"2024-09-01 03:15", "2024-09-01 03:20", "2024-09-02 03:10"}},
+		{"0 9,17 * * *", "2024-09-01 09:00", []string{"2024-09-01 17:00", "2024-09-02 09:00"}},
+		{"30 9-17 * * 1-5", "2024-09-06 17:30", []string{"2024-09-09 09:30", "2024-09-09 10:30"}}, // пятница -> понедельник
+		{"0 0 1 1,7 *", "2024-02-01 00:00", []string{"2024-07-01 00:00", "2025-01-01 00:00"}},
+		{"0 0 31 * *", "2024-09-15 00:00", []string{"2024-10-31 00:00", "2024-12-31 00:00"}},
+		{"0 0 29 2 *", "2025-01-01 00:00", []string{"2028-02-29 00:00"}},
+		// день недели 0 и 7 - воскресенье
+		{"0 0 * * 0", "2024-08-31 12:00", []string{"2024-09-01 00:00", "2024-09-08 00:00"}},
+		{"0 0 * * 7", "2024-08-31 12:00", []string{"2024-09-01 00:00", "2024-09-08 00:00"}},
+		// задан только день месяца или только день недели - он и проверяется
+		{"0 12 10 * *", "2024-09-01 00:00", []string{"2024-09-10 12:00", "2024-10-10 12:00"}},
+		{"0 12 * * 1", "2024-09-01 00:00", []string{"2024-09-02 12:00", "2024-09-09 12:00", "2024-09-16 12:00"}},
+		// заданы оба - достаточно совпадения одного: 10 число или понедельник
+		{"0 12 10 * 1", "2024-09-01 00:00", []string{"2024-09-02 12:00", "2024-09-09 12:00", "2024-09-10 12:00", "2024-09-16 12:00"}},
+		{"0 12 10/10 * 1,3", "2024-09-16 13:00", []string{"2024-09-18 12:00", "2024-09-20 12:00", "2024-09-23 12:00"}},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.expr)
+		if err != nil {
+			t.Errorf("%q: %v", tt.expr, err)
+			continue
+		}
+		next := at(tt.from)
+		for _, want := range tt.wants {
+			next = c.next(next)
+			if !next.Equal(at(want)) {
+				t.Errorf("%q: got %v, want %s", tt.expr, next, want)
+				break
+			}
+		}
+	}
+}
+
+func TestCronNeverMatches(t *testing.T) {
+	for _, expr := range []string{"0 0 31 2 *", "0 0 30 2 *", "0 0 31 4,6,9,11 *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("%q: expected error for schedule without runs", expr)
+		}
+	}
+	// next ищет запуск не дальше 5 лет
+	c := &cronSchedule{minute: 1, hour: 1, dom: 1 << 30, month: 1 << 2, dow: 1<<7 - 1, dowAny: true}
+	if got := c.next(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); !got.IsZero() {
+		t.Errorf("got %v, want zero time", got)
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"5-3 * * * *",
+		"1-x * * * *",
+		"-1 * * * *",
+		"a * * * *",
+		"1,,2 * * * *",
+		"MON * * * *",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("%q: expected error", expr)
+		}
+	}
+}
@@ -1,16 +1,72 @@
 package main
 
 import (
-	"log"
+	"context"
+	"flag"
+	"fmt"
 	"net/http"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
-	loadConfig()
+	cl, err := parseCommandLine(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+	setupConfig(cl)
+	if cl.printConfig {
+		cfg, err := readConfig()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Ошибка в конфигурации:", err)
+			os.Exit(1)
+		}
+		printConfig(os.Stdout, cfg)
+		return
+	}
+	if err := loadConfig(); err != nil {
+		logError(context.Background(), "Ошибка в конфигурационном файле", "error", err)
+		os.Exit(1)
+	}
+	cfg := currentConfig()
+	jobs = newJobManager(int(cfg.JobWorkers), int(cfg.JobQueueSize))
+	if cfg.HistoryFile != "" {
+		h, err := openHistory(cfg.HistoryFile)
+		if err != nil {
+			logError(context.Background(), "Ошибка открытия базы истории, история не ведется", "file", cfg.HistoryFile, "error", err)
+		} else {
+			history = h
+		}
+	}
+	monitors = newScheduler()
+	monitors.load(cfg.Monitors)
+	ranks = newRankTracker()
+	ranks.set(cfg.RankTracking)
+	go runProviderChecks()
 	mux := http.NewServeMux()
-	mux.HandleFunc("/sites", searchSites)
-	mux.HandleFunc("/sitesclient", clientSearchSites)
+	mux.Handle("/sites", instrument("/sites", searchSites))
+	mux.Handle("/sites/stream", instrument("/sites/stream", searchSitesStream))
+	mux.Handle("/sitesclient", instrument("/sitesclient", clientSearchSites))
+	mux.Handle("/check", instrument("/check", checkURLs))
+	mux.Handle("/jobs", instrument("/jobs", jobsHandler))
+	mux.Handle("/jobs/", instrument("/jobs/", jobHandler))
+	mux.Handle("/history", instrument("/history", historyHandler))
+	mux.Handle("/history/", instrument("/history/", historyHandler))
+	mux.Handle("/monitors", instrument("/monitors", monitorsHandler))
+	mux.Handle("/monitors/", instrument("/monitors/", monitorHandler))
+	mux.Handle("/ranks", instrument("/ranks", ranksHandler))
+	mux.Handle("/ranks/history", instrument("/ranks/history", rankHistoryHandler))
+	mux.Handle("/ranksclient", instrument("/ranksclient", ranksClientHandler))
+	mux.Handle("/config", instrument("/config", configHandler))
+	mux.Handle("/healthz", http.HandlerFunc(healthzHandler))
+	mux.Handle("/readyz", http.HandlerFunc(readyzHandler))
+	mux.Handle("/version", http.HandlerFunc(versionHandler))
+	mux.Handle("/metrics", promhttp.Handler())
 
-	log.Println("Слушаем порт :8080...")
-	http.ListenAndServe(":8080", mux)
+	logInfo(context.Background(), "Слушаем порт", "addr", cfg.ListenAddr, "version", version)
+	os.Exit(serve(&http.Server{Addr: cfg.ListenAddr, Handler: withRequestLogging(mux)}))
 }
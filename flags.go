@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const envPrefix = "DEMO_SERVICE_"
+
+// configOption параметр config.yaml, который можно переопределить переменной окружения и флагом командной строки.
+// Имя переменной - DEMO_SERVICE_ и имя флага в верхнем регистре с _ вместо -, например DEMO_SERVICE_TIMEOUT_WORK.
+type configOption struct {
+	key   string // ключ config.yaml
+	flag  string
+	usage string
+}
+
+var configOptions = []configOption{
+	{"ListenAddr", "listen", "адрес HTTP сервера, например :8080"},
+	{"TemplateDir", "template-dir", "папка шаблонов страниц"},
+	{"LogLevel", "log-level", "уровень журнала: debug, info, warn, error"},
+	{"TraceExporter", "trace-exporter", "выгрузка трассировки: file, otlp или пустая строка - выключена"},
+	{"TraceFile", "trace-file", "файл спанов для --trace-exporter file"},
+	{"TraceEndpoint", "trace-endpoint", "адрес OTLP/HTTP коллектора, например http://127.0.0.1:4318/v1/traces"},
+	{"DrainTimeout", "drain-timeout", "время на завершение проверок при остановке в миллисекундах"},
+	{"ShutdownDelay", "shutdown-delay", "задержка закрытия сервера после перехода в not ready в миллисекундах"},
+	{"TimeOutRequest", "timeout-request", "таймаут одиночного запроса в миллисекундах"},
+	{"TimeOutWork", "timeout-work", "таймаут полного запроса в миллисекундах"},
+	{"CountRequest", "count-request", "количество запросов по одному сайту"},
+	{"ClientSearchPoint", "client-search-point", "адрес /sites для /sitesclient?live=0"},
+	{"SearchProvider", "provider", "провайдер поиска по умолчанию: yandex, bing, duckduckgo, searxng"},
+	{"SearxngURL", "searxng-url", "адрес JSON API SearXNG"},
+	{"ProviderCheckInterval", "provider-check-interval", "период проверки доступности поисковика для /readyz в миллисекундах, 0 - не проверять"},
+	{"YandexPages", "yandex-pages", "максимум страниц выдачи Яндекса"},
+	{"YandexHosts", "yandex-hosts", "прекратить загрузку страниц, набрав столько сайтов"},
+	{"YandexRegion", "yandex-region", "код региона выдачи Яндекса"},
+	{"YandexLang", "yandex-lang", "язык выдачи Яндекса"},
+	{"YandexFamily", "yandex-family", "семейный фильтр Яндекса: none, moderate, strict"},
+	{"SerpDumpDir", "serp-dump-dir", "папка для страниц выдачи, которые не удалось разобрать"},
+	{"TransportMode", "transport-mode", "режим соединений: cold или warm"},
+	{"MaxConnsPerHost", "max-conns-per-host", "максимум соединений к одному хосту, 0 - без ограничения"},
+	{"MaxIdleConnsPerHost", "max-idle-conns-per-host", "максимум простаивающих соединений к одному хосту"},
+	{"IdleConnTimeout", "idle-conn-timeout", "время жизни простаивающего соединения в миллисекундах"},
+	{"HTTP2", "http2", "разрешить HTTP/2: true или false"},
+	{"CheckWorkers", "check-workers", "общее количество одновременно проверяемых сайтов"},
+	{"RequestCheckWorkers", "request-check-workers", "количество одновременно проверяемых сайтов в одном запросе"},
+	{"JobWorkers", "job-workers", "количество одновременно выполняемых фоновых заданий"},
+	{"JobQueueSize", "job-queue-size", "размер очереди фоновых заданий"},
+	{"JobRetention", "job-retention", "время хранения завершенных заданий в миллисекундах"},
+	{"HistoryFile", "history-file", "база истории проверок, пустая строка - история не ведется"},
+	{"MetricsMaxHosts", "metrics-max-hosts", "количество хостов с собственной меткой в метриках"},
+	{"MonitorJitter", "monitor-jitter", "случайная задержка запуска мониторов в миллисекундах"},
+	{"TargetPolicy.AllowPrivate", "allow-private-targets", "разрешить проверку частных, loopback и link-local адресов: true или false"},
+	{"RateLimit.HostRPS", "host-rps", "запросов в секунду к одному проверяемому хосту, 0 - без ограничения"},
+	{"RateLimit.HostBurst", "host-burst", "запросов к хосту подряд без ожидания"},
+	{"RateLimit.HostSpacing", "host-spacing", "минимальный интервал между запросами к одному хосту в миллисекундах"},
+	{"RateLimit.GlobalRPS", "global-rps", "запросов в секунду ко всем проверяемым сайтам, 0 - без ограничения"},
+	{"RateLimit.GlobalBurst", "global-burst", "запросов ко всем сайтам подряд без ожидания"},
+	{"RateLimit.MaxRetryAfter", "max-retry-after", "максимальная пауза по Retry-After в миллисекундах, 0 - не учитывать Retry-After"},
+}
+
+func (o configOption) env() string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(o.flag, "-", "_"))
+}
+
+// commandLine разобранные флаги командной строки
+type commandLine struct {
+	configFile  string
+	printConfig bool
+	overrides   map[string]string // ключ config.yaml -> значение флага, только явно заданные флаги
+}
+
+// parseCommandLine разбирает флаги. Порядок применения значений, от высшего к низшему:
+// флаг командной строки, переменная окружения DEMO_SERVICE_*, config.yaml, значение по умолчанию.
+func parseCommandLine(args []string) (commandLine, error) {
+	fs := flag.NewFlagSet("demo-service", flag.ContinueOnError)
+	var cl commandLine
+	fs.StringVar(&cl.configFile, "config", os.Getenv(envPrefix+"CONFIG"),
+		"путь к config.yaml (по умолчанию ищется в /opt/demo-service и текущей папке), "+envPrefix+"CONFIG")
+	fs.BoolVar(&cl.printConfig, "print-config", false, "вывести итоговую конфигурацию с учетом переменных окружения и флагов и выйти")
+	values := make(map[string]*string, len(configOptions))
+	for _, o := range configOptions {
+		values[o.flag] = fs.String(o.flag, "", o.usage+", "+o.env())
+	}
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintln(out, "Использование: demo-service [флаги]")
+		fmt.Fprintln(out, "Значения параметров: флаг, затем переменная окружения "+envPrefix+"*, затем config.yaml, затем значение по умолчанию.")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return cl, err
+	}
+	if fs.NArg() > 0 {
+		// как ошибки разбора флагов в flag: сообщение и справка
+		err := fmt.Errorf("unexpected arguments: %v", fs.Args())
+		fmt.Fprintln(fs.Output(), err)
+		fs.Usage()
+		return cl, err
+	}
+	cl.overrides = make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		for _, o := range configOptions {
+			if o.flag == f.Name {
+				cl.overrides[o.key] = *values[o.flag]
+			}
+		}
+	})
+	return cl, nil
+}
+
+// bindOverrides подключает переменные окружения и флаги к viper: значения флагов
+// имеют высший приоритет и сохраняются при перезагрузке config.yaml
+func bindOverrides(cl commandLine) {
+	for _, o := range configOptions {
+		viper.BindEnv(o.key, o.env())
+	}
+	for key, value := range cl.overrides {
+		viper.Set(key, value)
+	}
+}
+
+// printConfig выводит итоговую конфигурацию в JSON
+func printConfig(w io.Writer, cfg *Config) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		File   string
+		Config *Config
+	}{viper.ConfigFileUsed(), cfg})
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// версия сборки, задается при сборке: go build -ldflags "-X main.version=1.2.0 -X main.commit=abc123"
+var (
+	version = "dev"
+	commit  = "unknown"
+)
+
+// readinessCheck условие готовности сервиса к запросам, nil - условие выполнено
+type readinessCheck func() error
+
+var readiness struct {
+	mu     sync.Mutex
+	checks map[string]readinessCheck
+}
+
+// registerReadinessCheck добавляет условие готовности для /readyz, повторная регистрация имени заменяет условие
+func registerReadinessCheck(name string, check readinessCheck) {
+	readiness.mu.Lock()
+	defer readiness.mu.Unlock()
+	if readiness.checks == nil {
+		readiness.checks = make(map[string]readinessCheck)
+	}
+	readiness.checks[name] = check
+}
+
+// ReadinessView ответ /readyz: результат каждого условия, "ok" или текст ошибки
+type ReadinessView struct {
+	Ready  bool
+	Checks map[string]string
+}
+
+func checkReadiness() ReadinessView {
+	readiness.mu.Lock()
+	checks := make(map[string]readinessCheck, len(readiness.checks))
+	for name, check := range readiness.checks {
+		checks[name] = check
+	}
+	readiness.mu.Unlock()
+
+	v := ReadinessView{Ready: true, Checks: make(map[string]string, len(checks))}
+	for name, check := range checks {
+		if err := check(); err != nil {
+			v.Ready = false
+			v.Checks[name] = err.Error()
+		} else {
+			v.Checks[name] = "ok"
+		}
+	}
+	return v
+}
+
+// healthzHandler GET /healthz - процесс жив и обслуживает запросы
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// readyzHandler GET /readyz - 200, если выполнены все условия готовности, иначе 503; в теле - результат каждого условия
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	v := checkReadiness()
+	status := 200
+	if !v.Ready {
+		status = 503
+	}
+	writeJSON(w, status, v)
+}
+
+// VersionView ответ /version
+type VersionView struct {
+	Version    string
+	Commit     string
+	GoVersion  string
+	ConfigHash string // хеш действующей конфигурации, меняется при перезагрузке config.yaml
+}
+
+// versionHandler GET /version - версия сборки и хеш действующей конфигурации
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	configState.mu.Lock()
+	hash := configState.hash
+	configState.mu.Unlock()
+	writeJSON(w, 200, VersionView{Version: version, Commit: commit, GoVersion: runtime.Version(), ConfigHash: hash})
+}
+
+// результат последней проверки доступности поисковика по умолчанию
+var providerCheck struct {
+	mu        sync.Mutex
+	provider  string
+	err       error
+	checkedAt time.Time
+}
+
+func init() {
+	registerReadinessCheck("provider", providerReadiness)
+}
+
+// providerReadiness условие готовности по последней проверке поисковика, без запроса к нему
+func providerReadiness() error {
+	cfg := currentConfig()
+	if cfg.ProviderCheckInterval == 0 {
+		return nil
+	}
+	providerCheck.mu.Lock()
+	defer providerCheck.mu.Unlock()
+	switch {
+	case providerCheck.checkedAt.IsZero() || providerCheck.provider != cfg.SearchProvider:
+		return fmt.Errorf("search provider %s not checked yet", cfg.SearchProvider)
+	case providerCheck.err != nil:
+		return providerCheck.err
+	case time.Since(providerCheck.checkedAt) > 2*cfg.providerCheckInterval()+cfg.timeOutRequest():
+		return fmt.Errorf("search provider check is stale, last at %s", providerCheck.checkedAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// runProviderChecks проверяет доступность поисковика по умолчанию при запуске и каждые ProviderCheckInterval
+func runProviderChecks() {
+	for {
+		cfg := currentConfig()
+		if cfg.ProviderCheckInterval == 0 {
+			time.Sleep(time.Minute)
+			continue
+		}
+		err := checkProvider(cfg)
+		providerCheck.mu.Lock()
+		providerCheck.provider = cfg.SearchProvider
+		providerCheck.err = err
+		providerCheck.checkedAt = time.Now()
+		providerCheck.mu.Unlock()
+		if err != nil {
+			logWarn(context.Background(), "Поисковик недоступен", "provider", cfg.SearchProvider, "error", err)
+		}
+		time.Sleep(cfg.providerCheckInterval())
+	}
+}
+
+// checkProvider запрашивает адрес поисковика, любой ответ кроме 5xx считается доступностью
+func checkProvider(cfg *Config) error {
+	p, err := getSearchProvider(cfg.SearchProvider)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeOutRequest())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Endpoint(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", searchUserAgent)
+	resp, err := (&http.Client{Transport: pooledTransport()}).Do(req)
+	if err != nil {
+		return fmt.Errorf("search provider %s: %w", p.Name(), err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("search provider %s: unexpected status %s", p.Name(), resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	historyDefaultLimit = 100
+	historyMaxLimit     = 1000
+)
+
+var (
+	historyRunsBucket  = []byte("runs")  // ключ запуска -> historyRun в JSON
+	historyHostsBucket = []byte("hosts") // хост -> вложенный bucket с ключами запусков
+)
+
+// historyRun сохраненный результат одной проверки
+type historyRun struct {
+	ID       string
+	Time     time.Time
+	Duration time.Duration
+	Query    string   `json:",omitempty"`
+	Provider string   `json:",omitempty"`
+	Urls     []string `json:",omitempty"`
+	Monitor  string   `json:",omitempty"` // монитор, запустивший проверку
+	Config   historyConfig
+	Results  map[string]ResponseData
+}
+
+// historyConfig параметры, с которыми выполнялась проверка
+type historyConfig struct {
+	CountRequest   uint64
+	TimeOutRequest time.Duration
+	TimeOutWork    time.Duration
+	Workers        int
+	Mode           TransportMode
+}
+
+// hostRecord результат проверки одного сайта в одном из запусков
+type hostRecord struct {
+	RunID    string
+	Time     time.Time
+	Query    string `json:",omitempty"`
+	Provider string `json:",omitempty"`
+	Monitor  string `json:",omitempty"`
+	Site     string
+	Data     ResponseData
+}
+
+// historyFilter условия выборки истории
+type historyFilter struct {
+	Query    string
+	Provider string
+	Monitor  string
+	From     time.Time
+	To       time.Time
+	Limit    int
+}
+
+func (f historyFilter) match(run *historyRun) bool {
+	return (f.Query == "" || f.Query == run.Query) &&
+		(f.Provider == "" || f.Provider == run.Provider) &&
+		(f.Monitor == "" || f.Monitor == run.Monitor)
+}
+
+// historyStore история проверок во встроенной базе bbolt
+type historyStore struct {
+	db *bolt.DB
+}
+
+var history *historyStore // nil - история не ведется
+
+func openHistory(path string) (*historyStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{historyRunsBucket, historyHostsBucket, monitorsBucket, monitorRunsBucket, rankRunsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &historyStore{db: db}, nil
+}
+
+func (h *historyStore) close() error {
+	return h.db.Close()
+}
+
+// runKey ключ запуска: время в наносекундах и порядковый номер, ключи упорядочены по времени
+func runKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+func timeKey(t time.Time) []byte {
+	return runKey(t, 0)
+}
+
+// historyHost хост для индекса: при проверке списка адресов ключ результата - адрес
+func historyHost(site string) string {
+	if u, err := url.Parse(site); err == nil && u.Host != "" {
+		return strings.ToLower(u.Hostname())
+	}
+	return strings.ToLower(site)
+}
+
+func (h *historyStore) save(run *historyRun) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		runs := tx.Bucket(historyRunsBucket)
+		seq, err := runs.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := runKey(run.Time, seq)
+		run.ID = hex.EncodeToString(key)
+		b, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		if err := runs.Put(key, b); err != nil {
+			return err
+		}
+		hosts := tx.Bucket(historyHostsBucket)
+		for site := range run.Results {
+			hb, err := hosts.CreateBucketIfNotExists([]byte(historyHost(site)))
+			if err != nil {
+				return err
+			}
+			if err := hb.Put(key, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// scan перебирает ключи bucket от новых к старым в интервале [f.From, f.To]
+func scan(c *bolt.Cursor, f historyFilter, fn func(k, v []byte) bool) {
+	var k, v []byte
+	if f.To.IsZero() {
+		k, v = c.Last()
+	} else if k, v = c.Seek(timeKey(f.To.Add(time.Nanosecond))); k == nil {
+		k, v = c.Last()
+	} else {
+		k, v = c.Prev()
+	}
+	from := timeKey(f.From)
+	for ; k != nil; k, v = c.Prev() {
+		if !f.From.IsZero() && string(k) < string(from) {
+			return
+		}
+		if !fn(k, v) {
+			return
+		}
+	}
+}
+
+// runs запуски, подходящие под фильтр, от новых к старым
+func (h *historyStore) runs(f historyFilter) ([]historyRun, error) {
+	res := make([]historyRun, 0)
+	err := h.db.View(func(tx *bolt.Tx) error {
+		var err error
+		scan(tx.Bucket(historyRunsBucket).Cursor(), f, func(k, v []byte) bool {
+			var run historyRun
+			if err = json.Unmarshal(v, &run); err != nil {
+				return false
+			}
+			if f.match(&run) {
+				res = append(res, run)
+			}
+			return len(res) < f.Limit
+		})
+		return err
+	})
+	return res, err
+}
+
+// hostRuns результаты проверок хоста, подходящие под фильтр, от новых к старым
+func (h *historyStore) hostRuns(host string, f historyFilter) ([]hostRecord, error) {
+	res := make([]hostRecord, 0)
+	host = strings.ToLower(host)
+	err := h.db.View(func(tx *bolt.Tx) error {
+		hb := tx.Bucket(historyHostsBucket).Bucket([]byte(host))
+		if hb == nil {
+			return nil
+		}
+		runs := tx.Bucket(historyRunsBucket)
+		var err error
+		scan(hb.Cursor(), f, func(k, _ []byte) bool {
+			var run historyRun
+			if err = json.Unmarshal(runs.Get(k), &run); err != nil {
+				return false
+			}
+			if !f.match(&run) {
+				return true
+			}
+			for site, data := range run.Results {
+				if historyHost(site) == host {
+					res = append(res, hostRecord{RunID: run.ID, Time: run.Time, Query: run.Query, Provider: run.Provider, Monitor: run.Monitor, Site: site, Data: data})
+				}
+			}
+			return len(res) < f.Limit
+		})
+		return err
+	})
+	return res, err
+}
+
+// saveHistory сохраняет результат проверки, если история включена
+func saveHistory(spec checkSpec, settings probeSettings, timeOutWork time.Duration, start time.Time, results map[string]ResponseData) {
+	if history == nil {
+		return
+	}
+	workers := spec.Workers
+	if workers == 0 {
+		workers = int(currentConfig().RequestCheckWorkers)
+	}
+	mode := settings.Mode
+	if mode == "" {
+		_, mode = probeTransport("")
+	}
+	run := &historyRun{
+		Time:     start,
+		Duration: time.Since(start),
+		Query:    spec.Search,
+		Provider: spec.Provider,
+		Urls:     spec.Urls,
+		Monitor:  spec.Monitor,
+		Config: historyConfig{
+			CountRequest:   settings.CountRequest,
+			TimeOutRequest: settings.TimeOutRequest,
+			TimeOutWork:    timeOutWork,
+			Workers:        workers,
+			Mode:           mode,
+		},
+		Results: results,
+	}
+	if err := history.save(run); err != nil {
+		logError(context.Background(), "Ошибка сохранения истории", "error", err)
+	}
+}
+
+func parseHistoryFilter(r *http.Request) (historyFilter, error) {
+	q := r.URL.Query()
+	f := historyFilter{Query: q.Get("query"), Provider: q.Get("provider"), Monitor: q.Get("monitor"), Limit: historyDefaultLimit}
+	for name, dst := range map[string]*time.Time{"from": &f.From, "to": &f.To} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return f, fmt.Errorf("bad %s value %q, expected RFC3339", name, v)
+			}
+			*dst = t
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return f, fmt.Errorf("bad limit value %q", v)
+		}
+		f.Limit = n
+	}
+	if f.Limit > historyMaxLimit {
+		f.Limit = historyMaxLimit
+	}
+	return f, nil
+}
+
+// historyHandler GET /history - запуски проверок, GET /history/{host} - результаты проверок хоста.
+// Параметры: query, provider, monitor, from и to в RFC3339, limit.
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(405), 405)
+		return
+	}
+	if history == nil {
+		http.Error(w, "history is disabled", 404)
+		return
+	}
+	f, err := parseHistoryFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	var res interface{}
+	if host := strings.Trim(strings.TrimPrefix(r.URL.Path, "/history"), "/"); host != "" {
+		res, err = history.hostRuns(host, f)
+	} else {
+		res, err = history.runs(f)
+	}
+	if err != nil {
+		logError(r.Context(), "Ошибка чтения истории", "error", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	writeJSON(w, 200, res)
+}
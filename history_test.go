@@ -0,0 +1,116 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistoryScan(t *testing.T) {
+	h, err := openHistory(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.close()
+
+	t0 := time.Date(2024, 9, 1, 12, 0, 0, 0, time.UTC)
+	saved := []struct {
+		name  string
+		at    time.Time
+		query string
+		sites []string
+	}{
+		{"r1", t0, "go", []string{"https://example.com/"}},
+		{"r2", t0.Add(time.Second - time.Nanosecond), "go", []string{"https://other.org/"}},
+		{"r3", t0.Add(time.Second), "rust", []string{"https://Example.com/a", "https://other.org/"}},
+		{"r4", t0.Add(2 * time.Second), "go", []string{"example.com"}},
+		{"r5", t0.Add(2 * time.Second), "go", []string{"https://example.com:8080/"}}, // то же время, ключ больше
+		{"r6", t0.Add(2*time.Second + time.Nanosecond), "go", []string{"https://example.com/"}},
+		{"r7", t0.Add(3 * time.Second), "rust", []string{"https://other.org/"}},
+	}
+	for _, s := range saved {
+		run := &historyRun{Time: s.at, Query: s.query, Urls: []string{s.name}, Results: make(map[string]ResponseData)}
+		for _, site := range s.sites {
+			run.Results[site] = ResponseData{}
+		}
+		if err := h.save(run); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	at := func(d time.Duration) time.Time { return t0.Add(d) }
+	tests := []struct {
+		name   string
+		host   string
+		filter historyFilter
+		want   []string
+	}{
+		{"all", "", historyFilter{}, []string{"r7", "r6", "r5", "r4", "r3", "r2", "r1"}},
+		// границы включаются: запуски ровно в From и To попадают в выборку
+		{"inclusive", "", historyFilter{From: at(time.Second), To: at(2 * time.Second)}, []string{"r5", "r4", "r3"}},
+		{"exclusive from", "", historyFilter{From: at(time.Second + time.Nanosecond), To: at(2 * time.Second)}, []string{"r5", "r4"}},
+		{"exclusive to", "", historyFilter{From: at(time.Second), To: at(2*time.Second - time.Nanosecond)}, []string{"r3"}},
+		// поиск начинается с To+1ns: первый ключ после To - запуск r6 ровно в To+1ns
+		{"to before next", "", historyFilter{To: at(2 * time.Second)}, []string{"r5", "r4", "r3", "r2", "r1"}},
+		{"to at next", "", historyFilter{To: at(2*time.Second + time.Nanosecond)}, []string{"r6", "r5", "r4", "r3", "r2", "r1"}},
+		{"from only", "", historyFilter{From: at(2*time.Second + time.Nanosecond)}, []string{"r7", "r6"}},
+		{"single instant", "", historyFilter{From: at(0), To: at(0)}, []string{"r1"}},
+		{"to after last", "", historyFilter{To: at(time.Hour)}, []string{"r7", "r6", "r5", "r4", "r3", "r2", "r1"}},
+		{"to before first", "", historyFilter{To: at(-time.Nanosecond)}, nil},
+		{"from after last", "", historyFilter{From: at(3*time.Second + time.Nanosecond)}, nil},
+		{"query", "", historyFilter{Query: "rust"}, []string{"r7", "r3"}},
+		{"query and time", "", historyFilter{Query: "go", From: at(time.Second), To: at(2 * time.Second)}, []string{"r5", "r4"}},
+		{"limit", "", historyFilter{Limit: 2}, []string{"r7", "r6"}},
+
+		{"host", "example.com", historyFilter{}, []string{"r6", "r5", "r4", "r3", "r1"}},
+		{"host upper case", "EXAMPLE.COM", historyFilter{}, []string{"r6", "r5", "r4", "r3", "r1"}},
+		{"host inclusive", "example.com", historyFilter{From: at(time.Second), To: at(2 * time.Second)}, []string{"r5", "r4", "r3"}},
+		{"host exclusive", "example.com", historyFilter{From: at(time.Second + time.Nanosecond), To: at(2*time.Second - time.Nanosecond)}, nil},
+		{"host to", "other.org", historyFilter{To: at(time.Second)}, []string{"r3", "r2"}},
+		{"host from", "other.org", historyFilter{From: at(time.Second - time.Nanosecond)}, []string{"r7", "r3", "r2"}},
+		{"host query", "other.org", historyFilter{Query: "rust", To: at(3*time.Second - time.Nanosecond)}, []string{"r3"}},
+		{"unknown host", "example.net", historyFilter{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.filter.Limit == 0 {
+				tt.filter.Limit = historyDefaultLimit
+			}
+			var got []string
+			if tt.host == "" {
+				runs, err := h.runs(tt.filter)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, run := range runs {
+					got = append(got, run.Urls[0])
+				}
+			} else {
+				records, err := h.hostRuns(tt.host, tt.filter)
+				if err != nil {
+					t.Fatal(err)
+				}
+				names := make(map[string]string)
+				for _, run := range mustRuns(t, h) {
+					names[run.ID] = run.Urls[0]
+				}
+				for _, rec := range records {
+					got = append(got, names[rec.RunID])
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func mustRuns(t *testing.T, h *historyStore) []historyRun {
+	t.Helper()
+	runs, err := h.runs(historyFilter{Limit: historyMaxLimit})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runs
+}
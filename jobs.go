@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JobStatus состояние фонового задания
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobDone      JobStatus = "done"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+func (s JobStatus) finished() bool {
+	return s == JobDone || s == JobFailed || s == JobCancelled
+}
+
+// job фоновое задание на проверку сайтов
+type job struct {
+	mu       sync.Mutex
+	id       string
+	spec     checkSpec
+	status   JobStatus
+	created  time.Time
+	started  time.Time
+	finished time.Time
+	total    int
+	results  map[string]ResponseData
+	err      string
+	cancel   context.CancelFunc // отмена выполняющегося задания
+	reqID    string             // ID запроса, создавшего задание, для журнала
+}
+
+// JobView состояние задания для ответа API
+type JobView struct {
+	ID       string
+	Status   JobStatus
+	Spec     checkSpec
+	Created  time.Time
+	Started  *time.Time `json:",omitempty"`
+	Finished *time.Time `json:",omitempty"`
+	Total    int        // количество сайтов в проверке
+	Checked  int        // количество проверенных сайтов
+	Error    string     `json:",omitempty"`
+	Results  map[string]ResponseData
+}
+
+func (j *job) view() JobView {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	v := JobView{
+		ID:      j.id,
+		Status:  j.status,
+		Spec:    j.spec,
+		Created: j.created,
+		Total:   j.total,
+		Error:   j.err,
+		Results: make(map[string]ResponseData, len(j.results)),
+	}
+	if !j.started.IsZero() {
+		started := j.started
+		v.Started = &started
+	}
+	if !j.finished.IsZero() {
+		finished := j.finished
+		v.Finished = &finished
+	}
+	for host, data := range j.results {
+		v.Results[host] = data
+		if data.Checked {
+			v.Checked++
+		}
+	}
+	return v
+}
+
+func (j *job) finish(status JobStatus, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status.finished() {
+		return
+	}
+	j.status = status
+	j.finished = time.Now()
+	if err != nil {
+		j.err = err.Error()
+	}
+}
+
+var (
+	errQueueFull    = errors.New("job queue is full")
+	errShuttingDown = errors.New("service is shutting down")
+)
+
+// jobManager очередь и хранилище фоновых заданий
+type jobManager struct {
+	mu      sync.Mutex
+	jobs    map[string]*job
+	queue   chan *job
+	closed  bool           // очередь закрыта при остановке сервиса
+	workers sync.WaitGroup // исполнители, завершаются после выполнения всей очереди
+}
+
+var jobs *jobManager
+
+// newJobManager запускает workers исполнителей заданий с очередью на queueSize заданий
+func newJobManager(workers, queueSize int) *jobManager {
+	m := &jobManager{
+		jobs:  make(map[string]*job),
+		queue: make(chan *job, queueSize),
+	}
+	m.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go m.worker()
+	}
+	go m.cleanup()
+	return m
+}
+
+func (m *jobManager) submit(ctx context.Context, spec checkSpec) (*job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	j := &job{id: id, spec: spec, status: JobQueued, created: time.Now(), reqID: requestID(ctx)}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed || isDraining() {
+		return nil, errShuttingDown
+	}
+	select {
+	case m.queue <- j:
+	default:
+		return nil, errQueueFull
+	}
+	m.jobs[id] = j
+	return j, nil
+}
+
+// shutdown перестает принимать задания и ждет выполнения очереди и выполняющихся заданий.
+// По истечении ctx оставшиеся задания отменяются, возвращается ошибка ctx.
+func (m *jobManager) shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.queue)
+	}
+	m.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		m.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	m.mu.Lock()
+	for _, j := range m.jobs {
+		m.cancelJob(j)
+	}
+	m.mu.Unlock()
+	<-done
+	return ctx.Err()
+}
+
+func (m *jobManager) get(id string) (*job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	return j, ok
+}
+
+func (m *jobManager) remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.jobs, id)
+}
+
+func (m *jobManager) worker() {
+	defer m.workers.Done()
+	for j := range m.queue {
+		m.run(j)
+	}
+}
+
+func (m *jobManager) run(j *job) {
+	timeOutWork := currentConfig().timeOutWork()
+	ctx, cancel := context.WithTimeout(withRequestID(context.Background(), j.reqID), timeOutWork)
+	defer cancel()
+
+	j.mu.Lock()
+	if j.status != JobQueued { // отменено в очереди
+		j.mu.Unlock()
+		return
+	}
+	j.status = JobRunning
+	j.started = time.Now()
+	j.cancel = cancel
+	j.mu.Unlock()
+
+	_, err := executeCheck(ctx, j.spec, checkProgress{
+		Sites: func(items []responseItem) {
+			j.mu.Lock()
+			defer j.mu.Unlock()
+			j.total = len(items)
+			j.results = make(map[string]ResponseData, len(items))
+			for _, item := range items {
+				j.results[item.Host] = ResponseData{}
+			}
+		},
+		Result: func(host string, data ResponseData) {
+			j.mu.Lock()
+			defer j.mu.Unlock()
+			j.results[host] = data
+		},
+	})
+	// отмена во время поиска приходит ошибкой поиска, но задание не завершилось неудачей
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled) || errors.Is(err, context.Canceled):
+		j.finish(JobCancelled, nil)
+	case err != nil:
+		j.finish(JobFailed, err)
+	default:
+		j.finish(JobDone, nil)
+	}
+}
+
+// cancelJob отменяет задание в очереди или выполняющееся задание
+func (m *jobManager) cancelJob(j *job) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch j.status {
+	case JobQueued:
+		j.status = JobCancelled
+		j.finished = time.Now()
+	case JobRunning:
+		j.cancel()
+	}
+}
+
+// cleanup удаляет завершенные задания старше JobRetention
+func (m *jobManager) cleanup() {
+	for range time.Tick(time.Minute) {
+		retention := time.Millisecond * time.Duration(currentConfig().JobRetention)
+		m.mu.Lock()
+		for id, j := range m.jobs {
+			j.mu.Lock()
+			expired := j.status.finished() && time.Since(j.finished) > retention
+			j.mu.Unlock()
+			if expired {
+				delete(m.jobs, id)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// jobsHandler POST /jobs - создать задание (тело - JSON checkSpec), ответ 202 с состоянием задания
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(405), 405)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxCheckBodySize))
+	if err != nil {
+		http.Error(w, http.StatusText(413), 413)
+		return
+	}
+	var spec checkSpec
+	if err := json.Unmarshal(body, &spec); err != nil {
+		http.Error(w, fmt.Sprintf("bad JSON body: %v", err), 400)
+		return
+	}
+	if err := spec.validate(currentConfig()); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	j, err := jobs.submit(r.Context(), spec)
+	if errors.Is(err, errQueueFull) {
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, err.Error(), 503)
+		return
+	}
+	if errors.Is(err, errShuttingDown) {
+		http.Error(w, err.Error(), 503)
+		return
+	}
+	if err != nil {
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+j.id)
+	writeJSON(w, 202, j.view())
+}
+
+// jobHandler GET /jobs/{id} - состояние и частичные результаты,
+// DELETE /jobs/{id} - отменить задание, завершенное задание удаляется
+func jobHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	j, ok := jobs.get(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, 200, j.view())
+	case http.MethodDelete:
+		if j.view().Status.finished() {
+			jobs.remove(id)
+			w.WriteHeader(204)
+			return
+		}
+		jobs.cancelJob(j)
+		writeJSON(w, 202, j.view())
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodDelete)
+		http.Error(w, http.StatusText(405), 405)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
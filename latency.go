@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sort"
+	"sync"
+	"time"
+)
+
+// probeTimings длительности фаз одного запроса к сайту
+type probeTimings struct {
+	Total    time.Duration
+	DNS      time.Duration // разрешение имени
+	Connect  time.Duration // установка TCP соединения
+	TLS      time.Duration // TLS рукопожатие
+	TTFB     time.Duration // от отправки запроса до первого байта ответа
+	Transfer time.Duration // чтение тела ответа
+}
+
+// PhaseStats статистика длительности фазы по всем запросам к сайту
+type PhaseStats struct {
+	Min    time.Duration
+	Median time.Duration
+	P95    time.Duration
+	Max    time.Duration
+}
+
+// PhaseLatency разбивка времени доступа к сайту по фазам
+type PhaseLatency struct {
+	DNS      PhaseStats
+	Connect  PhaseStats
+	TLS      PhaseStats
+	TTFB     PhaseStats
+	Transfer PhaseStats
+}
+
+// phaseTracer собирает моменты событий запроса через httptrace
+type phaseTracer struct {
+	mu           sync.Mutex
+	dnsStart     time.Time
+	dnsDone      time.Time
+	connectStart time.Time
+	connectDone  time.Time
+	tlsStart     time.Time
+	tlsDone      time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+	connReused   bool
+}
+
+func (t *phaseTracer) set(p *time.Time, first bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// при нескольких попытках соединения берется начало первой и окончание последней
+	if first && !p.IsZero() {
+		return
+	}
+	*p = time.Now()
+}
+
+func (t *phaseTracer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { t.set(&t.dnsStart, true) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { t.set(&t.dnsDone, false) },
+		ConnectStart:         func(string, string) { t.set(&t.connectStart, true) },
+		ConnectDone:          func(string, string, error) { t.set(&t.connectDone, false) },
+		TLSHandshakeStart:    func() { t.set(&t.tlsStart, true) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { t.set(&t.tlsDone, false) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { t.set(&t.wroteRequest, false) },
+		GotFirstResponseByte: func() { t.set(&t.firstByte, true) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.connReused = info.Reused
+			t.mu.Unlock()
+		},
+	}
+}
+
+// reused запрос выполнен по ранее установленному соединению
+func (t *phaseTracer) reused() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.connReused
+}
+
+// timings считает длительности фаз, start - начало запроса, end - окончание чтения тела
+func (t *phaseTracer) timings(start, end time.Time) probeTimings {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return probeTimings{
+		Total:    end.Sub(start),
+		DNS:      between(t.dnsStart, t.dnsDone),
+		Connect:  between(t.connectStart, t.connectDone),
+		TLS:      between(t.tlsStart, t.tlsDone),
+		TTFB:     between(t.wroteRequest, t.firstByte),
+		Transfer: between(t.firstByte, end),
+	}
+}
+
+func between(from, to time.Time) time.Duration {
+	if from.IsZero() || to.IsZero() || to.Before(from) {
+		return 0
+	}
+	return to.Sub(from)
+}
+
+func phaseLatency(probes []probeTimings) PhaseLatency {
+	pick := func(f func(probeTimings) time.Duration) PhaseStats {
+		d := make([]time.Duration, len(probes))
+		for i, p := range probes {
+			d[i] = f(p)
+		}
+		return phaseStats(d)
+	}
+	return PhaseLatency{
+		DNS:      pick(func(p probeTimings) time.Duration { return p.DNS }),
+		Connect:  pick(func(p probeTimings) time.Duration { return p.Connect }),
+		TLS:      pick(func(p probeTimings) time.Duration { return p.TLS }),
+		TTFB:     pick(func(p probeTimings) time.Duration { return p.TTFB }),
+		Transfer: pick(func(p probeTimings) time.Duration { return p.Transfer }),
+	}
+}
+
+func phaseStats(d []time.Duration) PhaseStats {
+	if len(d) == 0 {
+		return PhaseStats{}
+	}
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	return PhaseStats{
+		Min:    d[0],
+		Median: percentile(d, 50),
+		P95:    percentile(d, 95),
+		Max:    d[len(d)-1],
+	}
+}
+
+// percentile значение по методу ближайшего ранга, d отсортирован
+func percentile(d []time.Duration, p int) time.Duration {
+	rank := (p*len(d) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return d[rank-1]
+}
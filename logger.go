@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// logLevel уровень записи журнала
+type logLevel int32
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = [...]string{"debug", "info", "warn", "error"}
+
+func (l logLevel) String() string {
+	return logLevelNames[l]
+}
+
+// parseLogLevel разбирает LogLevel из config.yaml, пустая строка - info
+func parseLogLevel(s string) (logLevel, error) {
+	if s == "" {
+		return levelInfo, nil
+	}
+	for i, name := range logLevelNames {
+		if s == name {
+			return logLevel(i), nil
+		}
+	}
+	return levelInfo, fmt.Errorf("bad LogLevel value %q, expected debug, info, warn or error", s)
+}
+
+var minLogLevel = int32(levelInfo)
+
+func setLogLevel(l logLevel) {
+	atomic.StoreInt32(&minLogLevel, int32(l))
+}
+
+func logEnabled(l logLevel) bool {
+	return int32(l) >= atomic.LoadInt32(&minLogLevel)
+}
+
+// журнал - JSON объект на строку: time, level, msg, request_id и trace_id (если есть в ctx) и пары ключ-значение kv
+var logOutput = struct {
+	sync.Mutex
+	w io.Writer
+}{w: os.Stderr}
+
+func logDebug(ctx context.Context, msg string, kv ...interface{}) { writeLog(ctx, levelDebug, msg, kv) }
+func logInfo(ctx context.Context, msg string, kv ...interface{})  { writeLog(ctx, levelInfo, msg, kv) }
+func logWarn(ctx context.Context, msg string, kv ...interface{})  { writeLog(ctx, levelWarn, msg, kv) }
+func logError(ctx context.Context, msg string, kv ...interface{}) { writeLog(ctx, levelError, msg, kv) }
+
+func writeLog(ctx context.Context, level logLevel, msg string, kv []interface{}) {
+	if !logEnabled(level) {
+		return
+	}
+	var b bytes.Buffer
+	b.WriteString(`{"time":`)
+	writeLogValue(&b, time.Now().Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeLogValue(&b, level.String())
+	b.WriteString(`,"msg":`)
+	writeLogValue(&b, msg)
+	if id := requestID(ctx); id != "" {
+		b.WriteString(`,"request_id":`)
+		writeLogValue(&b, id)
+	}
+	if sp := spanFromContext(ctx); sp != nil {
+		b.WriteString(`,"trace_id":`)
+		writeLogValue(&b, sp.traceIDString())
+	}
+	for i := 0; i < len(kv); i += 2 {
+		key := fmt.Sprint(kv[i])
+		var value interface{} = "(missing)"
+		if i+1 < len(kv) {
+			value = kv[i+1]
+		}
+		b.WriteByte(',')
+		writeLogValue(&b, key)
+		b.WriteByte(':')
+		writeLogValue(&b, value)
+	}
+	b.WriteString("}\n")
+
+	logOutput.Lock()
+	logOutput.w.Write(b.Bytes())
+	logOutput.Unlock()
+}
+
+// writeLogValue записывает значение в JSON: ошибки и длительности - строкой
+func writeLogValue(b *bytes.Buffer, v interface{}) {
+	switch x := v.(type) {
+	case error:
+		v = x.Error()
+	case time.Duration:
+		v = x.String()
+	case fmt.Stringer:
+		v = x.String()
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(data)
+}
+
+// requestIDHeader заголовок с ID запроса: принимается от клиента, возвращается в ответе
+// и передается в запросе к ClientSearchPoint, чтобы /sitesclient и вызванный им /sites имели общий ID
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func requestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID ID клиента принимается, если он не длиннее 64 символов из букв, цифр, - и _
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestLogging присваивает запросу ID из X-Request-ID или новый
+func withRequestLogging(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			var err error
+			if id, err = newJobID(); err != nil {
+				id = fmt.Sprintf("%x", time.Now().UnixNano())
+			}
+		}
+		w.Header().Set(requestIDHeader, id)
+		h.ServeHTTP(w, r.WithContext(withRequestID(r.Context(), id)))
+	})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const otherHostsLabel = "other" // метка для хостов сверх лимита MetricsMaxHosts
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "demo_service_http_requests_total",
+		Help: "HTTP requests served, by handler and status code.",
+	}, []string{"handler", "code"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "demo_service_http_request_duration_seconds",
+		Help:    "HTTP request latency, by handler.",
+		Buckets: []float64{.01, .05, .1, .5, 1, 2.5, 5, 10, 20, 30, 60},
+	}, []string{"handler"})
+
+	searchRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "demo_service_search_requests_total",
+		Help: "Search provider requests, by provider.",
+	}, []string{"provider"})
+
+	searchErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "demo_service_search_errors_total",
+		Help: "Failed search provider requests, by provider.",
+	}, []string{"provider"})
+
+	checksInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "demo_service_checks_in_flight",
+		Help: "Sites being checked right now.",
+	})
+
+	probeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "demo_service_probe_duration_seconds",
+		Help:    "Duration of successful probes, by target host.",
+		Buckets: []float64{.025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"host"})
+
+	probeRateLimitWait = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "demo_service_probe_rate_limit_wait_seconds_total",
+		Help: "Time probes spent waiting for the per-host and global rate limits.",
+	})
+
+	probeResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "demo_service_probes_total",
+		Help: "Probes sent, by target host and outcome class.",
+	}, []string{"host", "outcome"})
+
+	configReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "demo_service_config_reloads_total",
+		Help: "Config file reloads, by result (ok or rejected).",
+	}, []string{"result"})
+
+	configLastReloadOK = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "demo_service_config_last_reload_success",
+		Help: "1 if the last config load succeeded, 0 if it was rejected and the previous config is still active.",
+	})
+
+	configLoaded = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "demo_service_config_loaded_timestamp_seconds",
+		Help: "Unix time when the active config was loaded.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequests, httpDuration, searchRequests, searchErrors,
+		checksInFlight, probeDuration, probeResults, probeRateLimitWait, configReloads, configLastReloadOK, configLoaded)
+}
+
+// hostLabels ограничивает количество различных значений метки host:
+// первые MetricsMaxHosts хостов получают свою метку, остальные - other
+var hostLabels = struct {
+	sync.Mutex
+	seen map[string]struct{}
+}{seen: make(map[string]struct{})}
+
+func hostLabel(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return otherHostsLabel
+	}
+	host := strings.ToLower(u.Hostname())
+
+	hostLabels.Lock()
+	defer hostLabels.Unlock()
+	if _, ok := hostLabels.seen[host]; ok {
+		return host
+	}
+	if uint64(len(hostLabels.seen)) >= currentConfig().MetricsMaxHosts {
+		return otherHostsLabel
+	}
+	hostLabels.seen[host] = struct{}{}
+	return host
+}
+
+// observeProbe учитывает результат одиночного запроса к сайту
+func observeProbe(host string, p ProbeResult) {
+	probeResults.WithLabelValues(host, string(p.Outcome)).Inc()
+	if p.OK() {
+		probeDuration.WithLabelValues(host).Observe(p.Duration.Seconds())
+	}
+}
+
+// instrument добавляет к обработчику счетчик запросов, гистограмму времени выполнения и спан трассировки
+func instrument(name string, h http.HandlerFunc) http.Handler {
+	labels := prometheus.Labels{"handler": name}
+	return promhttp.InstrumentHandlerCounter(httpRequests.MustCurryWith(labels),
+		promhttp.InstrumentHandlerDuration(httpDuration.MustCurryWith(labels), traceHandler(name, h)))
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	minMonitorInterval = 10000                     // минимальный период запуска монитора в миллисекундах
+	maxScheduleDelay   = 366 * 24 * 60 * 60 * 1000 // максимальные период запуска и случайная задержка в миллисекундах
+)
+
+// validateSchedule ограничивает период запуска и случайную задержку в миллисекундах:
+// большие значения переполняют time.Duration
+func validateSchedule(interval, jitter uint64) error {
+	if interval > maxScheduleDelay {
+		return fmt.Errorf("interval %d is more than %d", interval, uint64(maxScheduleDelay))
+	}
+	if jitter > maxScheduleDelay {
+		return fmt.Errorf("jitter %d is more than %d", jitter, uint64(maxScheduleDelay))
+	}
+	return nil
+}
+
+var (
+	monitorsBucket    = []byte("monitors")     // имя -> Monitor в JSON, мониторы, созданные через API
+	monitorRunsBucket = []byte("monitor_runs") // имя -> время последнего запуска
+)
+
+var monitorNameRe = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Monitor периодическая проверка поискового запроса или списка адресов
+type Monitor struct {
+	Name      string        `json:"name"`
+	Search    string        `json:"search,omitempty"`
+	Provider  string        `json:"provider,omitempty"`
+	Urls      []string      `json:"urls,omitempty"`
+	Count     uint64        `json:"count,omitempty"`
+	TimeOut   uint64        `json:"timeout,omitempty"`
+	Workers   int           `json:"workers,omitempty"`
+	Group     string        `json:"group,omitempty"`
+	Mode      TransportMode `json:"mode,omitempty"`
+	Interval  uint64        `json:"interval,omitempty"`  // период запуска в миллисекундах
+	Cron      string        `json:"cron,omitempty"`      // расписание cron, вместо Interval
+	Jitter    uint64        `json:"jitter,omitempty"`    // случайная задержка запуска до Jitter миллисекунд, по умолчанию MonitorJitter
+	AllBlocks bool          `json:"allblocks,omitempty"` // проверять сайты рекламы и колдунщиков, по умолчанию только обычные результаты
+
+	SearchOptions `mapstructure:",squash"`
+}
+
+func (m *Monitor) spec() checkSpec {
+	return checkSpec{
+		Search:    m.Search,
+		Provider:  m.Provider,
+		Urls:      m.Urls,
+		Count:     m.Count,
+		TimeOut:   m.TimeOut,
+		Workers:   m.Workers,
+		Group:     m.Group,
+		Mode:      m.Mode,
+		Monitor:   m.Name,
+		AllBlocks: m.AllBlocks,
+
+		SearchOptions: m.SearchOptions,
+	}
+}
+
+// validate проверяет монитор по конфигурации cfg
+func (m *Monitor) validate(cfg *Config) error {
+	if !monitorNameRe.MatchString(m.Name) {
+		return fmt.Errorf("bad monitor name %q", m.Name)
+	}
+	if (m.Interval == 0) == (m.Cron == "") {
+		return fmt.Errorf("monitor %s: either interval or cron must be set", m.Name)
+	}
+	if m.Interval != 0 && m.Interval < minMonitorInterval {
+		return fmt.Errorf("monitor %s: interval %d is less than %d", m.Name, m.Interval, minMonitorInterval)
+	}
+	if err := validateSchedule(m.Interval, m.Jitter); err != nil {
+		return fmt.Errorf("monitor %s: %v", m.Name, err)
+	}
+	if m.Cron != "" {
+		if _, err := parseCron(m.Cron); err != nil {
+			return fmt.Errorf("monitor %s: %v", m.Name, err)
+		}
+	}
+	spec := m.spec()
+	if err := spec.validate(cfg); err != nil {
+		return fmt.Errorf("monitor %s: %v", m.Name, err)
+	}
+	return nil
+}
+
+// validateMonitors проверяет мониторы из config.yaml по проверяемой конфигурации cfg
+func validateMonitors(list []Monitor, cfg *Config) error {
+	names := make(map[string]struct{}, len(list))
+	for i := range list {
+		if err := list[i].validate(cfg); err != nil {
+			return err
+		}
+		if _, ok := names[list[i].Name]; ok {
+			return fmt.Errorf("duplicate monitor name %q", list[i].Name)
+		}
+		names[list[i].Name] = struct{}{}
+	}
+	return nil
+}
+
+// monitorRunner запускает один монитор по расписанию
+type monitorRunner struct {
+	monitor  Monitor
+	source   string // config или api
+	schedule *cronSchedule
+	stop     chan struct{}
+
+	mu           sync.Mutex
+	lastRun      time.Time
+	lastDuration time.Duration
+	lastError    string
+	nextRun      time.Time
+}
+
+// MonitorView состояние монитора для ответа API
+type MonitorView struct {
+	Monitor      Monitor
+	Source       string
+	Running      bool
+	LastRun      *time.Time    `json:",omitempty"`
+	LastDuration time.Duration `json:",omitempty"`
+	LastError    string        `json:",omitempty"`
+	NextRun      *time.Time    `json:",omitempty"`
+}
+
+// scheduler мониторы из config.yaml и созданные через API
+type scheduler struct {
+	mu      sync.Mutex
+	runners map[string]*monitorRunner
+	running map[string]bool // выполняющиеся мониторы, защита от наложения запусков
+	closed  bool            // сервис останавливается, новые мониторы не запускаются
+	loops   sync.WaitGroup
+	ctx     context.Context // отменяется, если мониторы не успели завершиться при остановке
+	cancel  context.CancelFunc
+}
+
+var monitors *scheduler
+
+func newScheduler() *scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &scheduler{
+		runners: make(map[string]*monitorRunner),
+		running: make(map[string]bool),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// shutdown останавливает расписание и ждет завершения выполняющихся мониторов,
+// по истечении ctx их проверки отменяются
+func (s *scheduler) shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	for _, r := range s.runners {
+		close(r.stop)
+	}
+	s.runners = make(map[string]*monitorRunner)
+	s.mu.Unlock()
+	return waitOrCancel(ctx, &s.loops, s.cancel)
+}
+
+// load запускает мониторы из config.yaml и сохраненные в базе мониторы, созданные через API
+func (s *scheduler) load(configMonitors []Monitor) {
+	var lastRuns map[string]time.Time
+	if history != nil {
+		apiMonitors, err := history.apiMonitors()
+		if err != nil {
+			logError(context.Background(), "Ошибка чтения мониторов", "error", err)
+		}
+		if lastRuns, err = history.monitorRuns(); err != nil {
+			logError(context.Background(), "Ошибка чтения мониторов", "error", err)
+		}
+		s.mu.Lock()
+		for _, m := range apiMonitors {
+			if err := m.validate(currentConfig()); err != nil {
+				logWarn(context.Background(), "Монитор пропущен", "error", err)
+				continue
+			}
+			s.startLocked(m, "api", lastRuns[m.Name])
+		}
+		s.mu.Unlock()
+	}
+	s.setConfigMonitors(configMonitors, lastRuns)
+}
+
+// setConfigMonitors приводит мониторы из config.yaml к новому списку
+func (s *scheduler) setConfigMonitors(list []Monitor, lastRuns map[string]time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make(map[string]struct{}, len(list))
+	for _, m := range list {
+		names[m.Name] = struct{}{}
+		r, ok := s.runners[m.Name]
+		if ok && r.source == "api" {
+			logWarn(context.Background(), "Монитор из config.yaml пропущен: монитор с таким именем создан через API", "monitor", m.Name)
+			continue
+		}
+		lastRun := lastRuns[m.Name]
+		if ok {
+			if monitorsEqual(r.monitor, m) {
+				continue
+			}
+			r.mu.Lock()
+			lastRun = r.lastRun
+			r.mu.Unlock()
+			close(r.stop)
+		}
+		s.startLocked(m, "config", lastRun)
+	}
+	for name, r := range s.runners {
+		if _, ok := names[name]; !ok && r.source == "config" {
+			close(r.stop)
+			delete(s.runners, name)
+		}
+	}
+}
+
+func monitorsEqual(a, b Monitor) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return string(ja) == string(jb)
+}
+
+func (s *scheduler) startLocked(m Monitor, source string, lastRun time.Time) {
+	if s.closed {
+		return
+	}
+	r := &monitorRunner{monitor: m, source: source, stop: make(chan struct{}), lastRun: lastRun}
+	if m.Cron != "" {
+		r.schedule, _ = parseCron(m.Cron)
+	}
+	s.runners[m.Name] = r
+	s.loops.Add(1)
+	go s.loop(r)
+}
+
+func (s *scheduler) add(m Monitor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errShuttingDown
+	}
+	if _, ok := s.runners[m.Name]; ok {
+		return fmt.Errorf("monitor %q already exists", m.Name)
+	}
+	if history != nil {
+		if err := history.saveMonitor(m); err != nil {
+			return err
+		}
+	}
+	s.startLocked(m, "api", time.Time{})
+	return nil
+}
+
+func (s *scheduler) remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.runners[name]
+	if !ok {
+		return nil
+	}
+	if r.source != "api" {
+		return fmt.Errorf("monitor %q is defined in config.yaml", name)
+	}
+	if history != nil {
+		if err := history.deleteMonitor(name); err != nil {
+			return err
+		}
+	}
+	close(r.stop)
+	delete(s.runners, name)
+	return nil
+}
+
+func (s *scheduler) get(name string) (*monitorRunner, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.runners[name]
+	return r, ok
+}
+
+func (s *scheduler) list() []MonitorView {
+	s.mu.Lock()
+	runners := make([]*monitorRunner, 0, len(s.runners))
+	for _, r := range s.runners {
+		runners = append(runners, r)
+	}
+	s.mu.Unlock()
+	sort.Slice(runners, func(i, j int) bool { return runners[i].monitor.Name < runners[j].monitor.Name })
+	views := make([]MonitorView, len(runners))
+	for i, r := range runners {
+		views[i] = s.view(r)
+	}
+	return views
+}
+
+func (s *scheduler) view(r *monitorRunner) MonitorView {
+	s.mu.Lock()
+	running := s.running[r.monitor.Name]
+	s.mu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v := MonitorView{Monitor: r.monitor, Source: r.source, Running: running, LastDuration: r.lastDuration, LastError: r.lastError}
+	if !r.lastRun.IsZero() {
+		lastRun := r.lastRun
+		v.LastRun = &lastRun
+	}
+	if !r.nextRun.IsZero() {
+		nextRun := r.nextRun
+		v.NextRun = &nextRun
+	}
+	return v
+}
+
+// nextRunAfter время следующего запуска с учетом случайной задержки
+func (r *monitorRunner) nextRunAfter(now time.Time) time.Time {
+	r.mu.Lock()
+	lastRun := r.lastRun
+	r.mu.Unlock()
+	return nextScheduledRun(now, lastRun, r.schedule, r.monitor.Interval, r.monitor.Jitter)
+}
+
+// nextScheduledRun время следующего запуска по расписанию cron либо через interval миллисекунд
+// после предыдущего запуска, со случайной задержкой до jitter миллисекунд (0 - MonitorJitter).
+// Нулевое время - расписание больше не наступит.
+func nextScheduledRun(now, lastRun time.Time, schedule *cronSchedule, interval, jitter uint64) time.Time {
+	var next time.Time
+	switch {
+	case schedule != nil:
+		if next = schedule.next(now); next.IsZero() {
+			return next
+		}
+	case lastRun.IsZero():
+		next = now
+	default:
+		next = lastRun.Add(time.Millisecond * time.Duration(interval))
+		if next.Before(now) {
+			next = now
+		}
+	}
+	if jitter == 0 {
+		jitter = currentConfig().MonitorJitter
+	}
+	if jitter > 0 {
+		next = next.Add(randomJitter(time.Millisecond * time.Duration(jitter)))
+	}
+	return next
+}
+
+// jitterRand источник случайной задержки запуска. Глобальный источник math/rand до Go 1.20 не инициализируется
+// случайным значением, и после каждого перезапуска сервиса задержки повторялись бы.
+var jitterRand = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+// randomJitter случайная задержка от 0 до max, 0 при max <= 0
+func randomJitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	jitterRand.Lock()
+	defer jitterRand.Unlock()
+	return time.Duration(jitterRand.Int63n(int64(max)))
+}
+
+func (s *scheduler) loop(r *monitorRunner) {
+	defer s.loops.Done()
+	for {
+		next := r.nextRunAfter(time.Now())
+		r.mu.Lock()
+		r.nextRun = next
+		r.mu.Unlock()
+		if next.IsZero() {
+			logWarn(context.Background(), "Расписание монитора не наступит, монитор остановлен", "monitor", r.monitor.Name, "cron", r.monitor.Cron)
+			<-r.stop
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-r.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.run(r)
+	}
+}
+
+func (s *scheduler) run(r *monitorRunner) {
+	name := r.monitor.Name
+	s.mu.Lock()
+	if s.running[name] {
+		s.mu.Unlock()
+		logWarn(context.Background(), "Монитор еще выполняется, запуск пропущен", "monitor", name)
+		return
+	}
+	s.running[name] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, name)
+		s.mu.Unlock()
+	}()
+
+	cfg := currentConfig()
+	ctx, cancel := context.WithTimeout(s.ctx, cfg.timeOutWork())
+	defer cancel()
+	start := time.Now()
+	// задание проверяется перед каждым запуском, как в /check: после перезагрузки config.yaml
+	// могли измениться TimeOutWork, CheckWorkers и SearchProvider
+	spec := r.monitor.spec()
+	err := spec.validate(cfg)
+	if err == nil {
+		_, err = executeCheck(ctx, spec, checkProgress{})
+	}
+
+	r.mu.Lock()
+	r.lastRun = start
+	r.lastDuration = time.Since(start)
+	r.lastError = ""
+	if err != nil {
+		r.lastError = err.Error()
+	}
+	r.mu.Unlock()
+	if err != nil {
+		logError(context.Background(), "Ошибка выполнения монитора", "monitor", name, "error", err)
+	}
+	if history != nil {
+		if err := history.saveMonitorRun(name, start); err != nil {
+			logError(context.Background(), "Ошибка сохранения монитора", "monitor", name, "error", err)
+		}
+	}
+}
+
+func (h *historyStore) saveMonitor(m Monitor) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return h.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(monitorsBucket).Put([]byte(m.Name), b)
+	})
+}
+
+func (h *historyStore) deleteMonitor(name string) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(monitorsBucket).Delete([]byte(name)); err != nil {
+			return err
+		}
+		return tx.Bucket(monitorRunsBucket).Delete([]byte(name))
+	})
+}
+
+func (h *historyStore) apiMonitors() ([]Monitor, error) {
+	var list []Monitor
+	err := h.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(monitorsBucket).ForEach(func(k, v []byte) error {
+			var m Monitor
+			if err := json.Unmarshal(v, &m); err != nil {
+				return err
+			}
+			list = append(list, m)
+			return nil
+		})
+	})
+	return list, err
+}
+
+func (h *historyStore) saveMonitorRun(name string, t time.Time) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(monitorRunsBucket).Put([]byte(name), []byte(t.Format(time.RFC3339Nano)))
+	})
+}
+
+func (h *historyStore) monitorRuns() (map[string]time.Time, error) {
+	runs := make(map[string]time.Time)
+	err := h.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(monitorRunsBucket).ForEach(func(k, v []byte) error {
+			t, err := time.Parse(time.RFC3339Nano, string(v))
+			if err == nil {
+				runs[string(k)] = t
+			}
+			return nil
+		})
+	})
+	return runs, err
+}
+
+// monitorsHandler GET /monitors - список мониторов, POST /monitors - создать монитор (тело - JSON Monitor)
+func monitorsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, 200, monitors.list())
+	case http.MethodPost:
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxCheckBodySize))
+		if err != nil {
+			http.Error(w, http.StatusText(413), 413)
+			return
+		}
+		var m Monitor
+		if err := json.Unmarshal(body, &m); err != nil {
+			http.Error(w, fmt.Sprintf("bad JSON body: %v", err), 400)
+			return
+		}
+		if err := m.validate(currentConfig()); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+		if err := monitors.add(m); errors.Is(err, errShuttingDown) {
+			http.Error(w, err.Error(), 503)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), 409)
+			return
+		}
+		rn, _ := monitors.get(m.Name)
+		w.Header().Set("Location", "/monitors/"+m.Name)
+		writeJSON(w, 201, monitors.view(rn))
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(w, http.StatusText(405), 405)
+	}
+}
+
+// monitorHandler GET /monitors/{name} - состояние монитора, DELETE /monitors/{name} - удалить монитор, созданный через API
+func monitorHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/monitors/")
+	rn, ok := monitors.get(name)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, 200, monitors.view(rn))
+	case http.MethodDelete:
+		if err := monitors.remove(name); err != nil {
+			http.Error(w, err.Error(), 409)
+			return
+		}
+		w.WriteHeader(204)
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodDelete)
+		http.Error(w, http.StatusText(405), 405)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const baseBingURL = "https://www.bing.com/search?count=50&q="
+
+func parseBingResponse(response []byte) (res responseStruct) {
+	res = responseStruct{Items: make([]responseItem, 0)}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(response))
+	if err != nil {
+		res.Error = fmt.Errorf("can't create parser for body: %v", err)
+		return
+	}
+	doc.Find("li.b_algo").Each(func(i int, selection *goquery.Selection) {
+		link := selection.Find("h2 a").First()
+		urlStr, ok := link.Attr("href")
+		if !ok {
+			return
+		}
+		u, err := url.Parse(urlStr)
+		if err != nil || u.Host == "" {
+			return
+		}
+		res.Items = append(res.Items, responseItem{
+			Host:     getRootDomain(u.Host),
+			Url:      urlStr,
+			Position: len(res.Items) + 1,
+			Title:    cleanText(link.Text()),
+			Snippet:  cleanText(selection.Find(".b_caption p").First().Text()),
+			Type:     SerpOrganic,
+		})
+	})
+	return res
+}
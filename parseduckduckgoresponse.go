@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const baseDuckDuckGoURL = "https://html.duckduckgo.com/html/?q="
+
+func parseDuckDuckGoResponse(response []byte) (res responseStruct) {
+	res = responseStruct{Items: make([]responseItem, 0)}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(response))
+	if err != nil {
+		res.Error = fmt.Errorf("can't create parser for body: %v", err)
+		return
+	}
+	doc.Find("div.result").Each(func(i int, selection *goquery.Selection) {
+		if selection.HasClass("result--ad") {
+			return
+		}
+		link := selection.Find("a.result__a").First()
+		urlStr, ok := link.Attr("href")
+		if !ok {
+			return
+		}
+		// ссылки в html-выдаче идут через редирект //duckduckgo.com/l/?uddg=<адрес>
+		if strings.HasPrefix(urlStr, "//") {
+			urlStr = "https:" + urlStr
+		}
+		u, err := url.Parse(urlStr)
+		if err != nil {
+			return
+		}
+		if strings.HasSuffix(u.Host, "duckduckgo.com") && u.Path == "/l/" {
+			urlStr = u.Query().Get("uddg")
+			if u, err = url.Parse(urlStr); err != nil {
+				return
+			}
+		}
+		if u.Host == "" {
+			return
+		}
+		res.Items = append(res.Items, responseItem{
+			Host:     getRootDomain(u.Host),
+			Url:      urlStr,
+			Position: len(res.Items) + 1,
+			Title:    cleanText(link.Text()),
+			Snippet:  cleanText(selection.Find(".result__snippet").First().Text()),
+			Type:     SerpOrganic,
+		})
+	})
+	return res
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// разбор сохраненных страниц выдачи каждого провайдера
+func TestParseResponse(t *testing.T) {
+	tests := []struct {
+		file  string
+		parse func([]byte) responseStruct
+		want  []responseItem
+	}{
+		{
+			file:  "yandex.html",
+			parse: parseYandexResponse,
+			want: []responseItem{
+				{Host: "golang.org", Url: "https://golang.org/doc/", Position: 1, Title: "Documentation - The Go Programming Language", Type: SerpOrganic},
+				{Host: "habr.com", Url: "https://habr.com/ru/post/123456/", Position: 2, Title: "Go: первые шаги", Type: SerpOrganic},
+				// ссылка на турбо-страницу заменяется адресом сайта из data-counter
+				{Host: "example.ru", Url: "https://example.ru/go", Position: 3, Title: "Go на примерах", Turbo: true, Type: SerpOrganic},
+				// колдунщик не получает места среди обычных результатов
+				{Host: "wikipedia.org", Url: "https://ru.wikipedia.org/wiki/Go", Title: "Go — Википедия", Type: SerpWizard},
+			},
+		},
+		{
+			file:  "bing.html",
+			parse: parseBingResponse,
+			want: []responseItem{
+				{Host: "go.dev", Url: "https://go.dev/learn/", Position: 1, Title: "Get Started - The Go Programming Language", Snippet: "Download and install Go quickly.", Type: SerpOrganic},
+				{Host: "w3schools.com", Url: "https://www.w3schools.com/go/", Position: 2, Title: "Go Tutorial - W3Schools", Snippet: "Go is a popular programming language.", Type: SerpOrganic},
+				{Host: "wikipedia.org", Url: "https://en.wikipedia.org/wiki/Go_(programming_language)", Position: 3, Title: "Go (programming language) - Wikipedia", Type: SerpOrganic},
+			},
+		},
+		{
+			file:  "duckduckgo.html",
+			parse: parseDuckDuckGoResponse,
+			want: []responseItem{
+				// адрес сайта извлекается из редиректа //duckduckgo.com/l/?uddg=, реклама пропускается
+				{Host: "go.dev", Url: "https://go.dev/", Position: 1, Title: "The Go Programming Language", Snippet: "Go is an open source programming language.", Type: SerpOrganic},
+				{Host: "gobyexample.com", Url: "https://gobyexample.com/", Position: 2, Title: "Go by Example", Type: SerpOrganic},
+				{Host: "github.com", Url: "https://github.com/golang/go", Position: 3, Title: "golang/go - GitHub", Type: SerpOrganic},
+			},
+		},
+		{
+			file:  "searxng.json",
+			parse: parseSearxngResponse,
+			want: []responseItem{
+				{Host: "go.dev", Url: "https://go.dev/", Position: 1, Title: "The Go Programming Language", Snippet: "Go is an open source programming language.", Type: SerpOrganic},
+				{Host: "go.dev", Url: "https://pkg.go.dev/std", Position: 2, Title: "Standard library", Snippet: "Go standard library.", Type: SerpOrganic},
+				{Host: "habr.com", Url: "https://habr.com/ru/hub/go/", Position: 3, Title: "Go / Хабр", Snippet: "Статьи о Go.", Type: SerpOrganic},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			body, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := tt.parse(body)
+			if res.Error != nil {
+				t.Fatalf("unexpected error: %v", res.Error)
+			}
+			if len(res.Items) != len(tt.want) {
+				t.Fatalf("got %d items, want %d: %+v", len(res.Items), len(tt.want), res.Items)
+			}
+			for i, want := range tt.want {
+				if got := res.Items[i]; got != want {
+					t.Errorf("item %d:\n got %+v\nwant %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseResponseBadInput(t *testing.T) {
+	if res := parseSearxngResponse([]byte("<html>")); res.Error == nil {
+		t.Error("expected error for non-JSON SearXNG response")
+	}
+	for name, parse := range map[string]func([]byte) responseStruct{
+		"bing":       parseBingResponse,
+		"duckduckgo": parseDuckDuckGoResponse,
+	} {
+		if res := parse([]byte("<html><body>nothing</body></html>")); res.Error != nil || len(res.Items) != 0 {
+			t.Errorf("%s: got %+v, want no items", name, res)
+		}
+	}
+}
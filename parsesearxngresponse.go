@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+type searxngResponse struct {
+	Results []struct {
+		URL     string `json:"url"`
+		Title   string `json:"title"`
+		Content string `json:"content"`
+	} `json:"results"`
+}
+
+func parseSearxngResponse(response []byte) (res responseStruct) {
+	res = responseStruct{Items: make([]responseItem, 0)}
+	var sr searxngResponse
+	if err := json.Unmarshal(response, &sr); err != nil {
+		res.Error = fmt.Errorf("can't decode searxng response: %v", err)
+		return
+	}
+	for _, r := range sr.Results {
+		u, err := url.Parse(r.URL)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		res.Items = append(res.Items, responseItem{
+			Host:     getRootDomain(u.Host),
+			Url:      r.URL,
+			Position: len(res.Items) + 1,
+			Title:    cleanText(r.Title),
+			Snippet:  cleanText(r.Content),
+			Type:     SerpOrganic,
+		})
+	}
+	return res
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ProbeOutcome класс результата одиночного запроса к сайту
+type ProbeOutcome string
+
+const (
+	OutcomeOK             ProbeOutcome = "ok"
+	OutcomeDNS            ProbeOutcome = "dns"
+	OutcomeConnectRefused ProbeOutcome = "connect_refused"
+	OutcomeTimeout        ProbeOutcome = "timeout"
+	OutcomeTLS            ProbeOutcome = "tls"
+	OutcomeHTTP4xx        ProbeOutcome = "http_4xx"
+	OutcomeHTTP5xx        ProbeOutcome = "http_5xx"
+	OutcomeRateLimited    ProbeOutcome = "rate_limited"
+	OutcomeBodyError      ProbeOutcome = "body_error"
+	OutcomeBlocked        ProbeOutcome = "blocked"   // адрес запрещен TargetPolicy
+	OutcomeCancelled      ProbeOutcome = "cancelled" // клиент отключился или истекло время TimeOutWork
+	OutcomeError          ProbeOutcome = "error"     // прочие ошибки запроса
+)
+
+// ProbeResult результат одиночного запроса к сайту
+type ProbeResult struct {
+	StatusCode int
+	Outcome    ProbeOutcome
+	Error      string
+	Duration   time.Duration
+	BytesRead  int64
+	Timings    probeTimings
+	Reused     bool          // соединение взято из пула
+	Waited     time.Duration // ожидание ограничителя частоты запросов перед отправкой
+}
+
+func (p ProbeResult) OK() bool {
+	return p.Outcome == OutcomeOK
+}
+
+// statusOutcome класс результата по коду ответа
+func statusOutcome(code int) ProbeOutcome {
+	switch {
+	case code == 429:
+		return OutcomeRateLimited
+	case code >= 500:
+		return OutcomeHTTP5xx
+	case code >= 400:
+		return OutcomeHTTP4xx
+	}
+	return OutcomeOK
+}
+
+// probeErrorOutcome класс результата запроса с контекстом parent: отмена parent - cancelled
+func probeErrorOutcome(parent context.Context, err error) ProbeOutcome {
+	if parent.Err() != nil {
+		return OutcomeCancelled
+	}
+	return errorOutcome(err)
+}
+
+// errorOutcome класс результата по ошибке выполнения запроса
+func errorOutcome(err error) ProbeOutcome {
+	var blockedErr *blockedTargetError
+	if errors.As(err, &blockedErr) {
+		return OutcomeBlocked
+	}
+	var limitErr *rateLimitError
+	if errors.As(err, &limitErr) {
+		return OutcomeRateLimited
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return OutcomeDNS
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		return OutcomeTimeout
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return OutcomeConnectRefused
+	}
+	var (
+		recordErr    tls.RecordHeaderError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+	if errors.As(err, &recordErr) || errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) ||
+		strings.Contains(err.Error(), "tls: ") ||
+		strings.Contains(err.Error(), "server gave HTTP response to HTTPS client") {
+		return OutcomeTLS
+	}
+	return OutcomeError
+}
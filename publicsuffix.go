@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	_ "embed"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Public Suffix List https://publicsuffix.org, разделы ICANN и PRIVATE.
+// Обновление из локального файла: make update-psl PSL=путь/к/public_suffix_list.dat
+//
+//go:generate go run ./cmd/update-psl -from /usr/share/publicsuffix/public_suffix_list.dat -to public_suffix_list.dat
+//go:embed public_suffix_list.dat
+var publicSuffixListData string
+
+// publicSuffixes правила списка в ASCII (punycode) форме: "com.ru", wildcard "*.ck", исключение "!www.ck"
+var publicSuffixes = parsePublicSuffixList(publicSuffixListData)
+
+func parsePublicSuffixList(data string) map[string]struct{} {
+	rules := make(map[string]struct{})
+	sc := bufio.NewScanner(strings.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		// правило заканчивается на первом пробельном символе
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			line = line[:i]
+		}
+		prefix := ""
+		switch {
+		case strings.HasPrefix(line, "!"):
+			prefix, line = "!", line[1:]
+		case strings.HasPrefix(line, "*."):
+			prefix, line = "*.", line[2:]
+		}
+		if ascii, err := idna.ToASCII(line); err == nil {
+			line = ascii
+		}
+		rules[prefix+strings.ToLower(line)] = struct{}{}
+	}
+	return rules
+}
+
+// publicSuffix публичный суффикс ASCII домена по алгоритму https://publicsuffix.org/list/
+func publicSuffix(domain string) string {
+	labels := strings.Split(domain, ".")
+	// правило по умолчанию "*": суффикс - последняя метка
+	suffix := labels[len(labels)-1]
+	for i := range labels {
+		name := strings.Join(labels[i:], ".")
+		if _, ok := publicSuffixes["!"+name]; ok {
+			// исключение: суффикс - имя без первой метки
+			return strings.Join(labels[i+1:], ".")
+		}
+		if _, ok := publicSuffixes[name]; ok {
+			return name
+		}
+		if i+1 < len(labels) {
+			if _, ok := publicSuffixes["*."+strings.Join(labels[i+1:], ".")]; ok {
+				return name
+			}
+		}
+	}
+	return suffix
+}
+
+// normalizeHost приводит имя хоста к ASCII форме в нижнем регистре без порта и завершающей точки
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(strings.TrimSuffix(strings.ToLower(host), "."), "[]")
+	if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+		host = ascii
+	}
+	return host
+}
+
+// getRootDomain регистрируемый домен (публичный суффикс и одна метка перед ним) в Unicode форме,
+// punycode и Unicode записи одного домена дают одинаковый результат
+func getRootDomain(domain string) string {
+	host := normalizeHost(domain)
+	if host == "" || net.ParseIP(host) != nil {
+		return host
+	}
+	suffix := publicSuffix(host)
+	root := host
+	if len(host) > len(suffix) {
+		rest := strings.TrimSuffix(host[:len(host)-len(suffix)], ".")
+		root = rest[strings.LastIndex(rest, ".")+1:] + "." + suffix
+	}
+	if unicode, err := idna.ToUnicode(root); err == nil {
+		return unicode
+	}
+	return root
+}
+
+// группировка результатов проверки, пустая строка - по умолчанию:
+// для поисковой выдачи по регистрируемому домену, для списка адресов по адресу
+var siteGroups = map[string]struct{}{"": {}, "domain": {}, "host": {}, "url": {}}
+
+// siteKey ключ результата проверки адреса при группировке group
+func siteKey(rawURL, group string) string {
+	if group == "url" {
+		return rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	if group == "host" {
+		host := normalizeHost(u.Host)
+		if unicode, err := idna.ToUnicode(host); err == nil {
+			return unicode
+		}
+		return host
+	}
+	return getRootDomain(u.Host)
+}
@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+func TestPublicSuffix(t *testing.T) {
+	tests := []struct {
+		domain, want string
+	}{
+		{"com", "com"},
+		{"example.com", "com"},
+		{"www.example.co.uk", "co.uk"},
+		{"co.uk", "co.uk"},
+		{"user.github.io", "github.io"},
+		{"a.b.user.github.io", "github.io"},
+		{"shop.msk.ru", "msk.ru"},
+		{"example.com.ru", "com.ru"},
+		{"xn--e1afmkfd.xn--p1ai", "xn--p1ai"},          // пример.рф
+		{"xn--80aswg.xn--80adxhks", "xn--80adxhks"},    // сайт.москва
+		{"xn--85x722f.xn--55qx5d.cn", "xn--55qx5d.cn"}, // 食狮.公司.cn
+		// wildcard *.ck и исключение !www.ck
+		{"ck", "ck"},
+		{"co.ck", "co.ck"},
+		{"example.co.ck", "co.ck"},
+		{"www.ck", "ck"},
+		{"sub.www.ck", "ck"},
+		// правила нет: суффикс - последняя метка
+		{"example.unknowntld", "unknowntld"},
+	}
+	for _, tt := range tests {
+		if got := publicSuffix(tt.domain); got != tt.want {
+			t.Errorf("publicSuffix(%q) = %q, want %q", tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestGetRootDomain(t *testing.T) {
+	tests := []struct {
+		host, want string
+	}{
+		{"example.com", "example.com"},
+		{"WWW.Example.COM.", "example.com"},
+		{"www.example.com:8080", "example.com"},
+		{"news.bbc.co.uk", "bbc.co.uk"},
+		{"co.uk", "co.uk"}, // сам суффикс
+		{"user.github.io", "user.github.io"},
+		{"a.b.user.github.io", "user.github.io"},
+		{"www.shop.msk.ru", "shop.msk.ru"},
+		{"a.example.co.ck", "example.co.ck"},
+		{"www.ck", "www.ck"},
+		{"a.www.ck", "www.ck"},
+		// Unicode и punycode записи дают одинаковый результат в Unicode форме
+		{"www.пример.рф", "пример.рф"},
+		{"www.xn--e1afmkfd.xn--p1ai", "пример.рф"},
+		{"WWW.ПРИМЕР.РФ", "пример.рф"},
+		{"a.сайт.москва", "сайт.москва"},
+		{"a.xn--80aswg.xn--80adxhks", "сайт.москва"},
+		{"www.食狮.公司.cn", "食狮.公司.cn"},
+		{"www.xn--85x722f.xn--55qx5d.cn", "食狮.公司.cn"},
+		// IP адреса не разбираются на метки
+		{"192.168.1.1", "192.168.1.1"},
+		{"[::1]:443", "::1"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := getRootDomain(tt.host); got != tt.want {
+			t.Errorf("getRootDomain(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
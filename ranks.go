@@ -0,0 +1,598 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var rankRunsBucket = []byte("rank_runs") // ключ запуска -> rankRun в JSON
+
+// RankTracking отслеживание мест своих доменов в выдаче по списку запросов
+type RankTracking struct {
+	Domains  []string
+	Keywords []string
+	Provider string
+	Interval uint64        // период запуска в миллисекундах
+	Cron     string        // расписание cron, вместо Interval
+	Jitter   uint64        // случайная задержка запуска до Jitter миллисекунд, по умолчанию MonitorJitter
+	Count    uint64        // количество запросов к найденному адресу, по умолчанию CountRequest
+	TimeOut  uint64        // таймаут одиночного запроса в миллисекундах, по умолчанию TimeOutRequest
+	Mode     TransportMode // режим соединений cold или warm, по умолчанию TransportMode
+	Pages    int           // параметры выдачи, как у /sites: максимум загружаемых страниц
+	Hosts    int           // прекратить загрузку страниц, набрав столько различных сайтов
+	Region   int           // код региона
+	Lang     string        // язык выдачи
+	Family   string        // семейный фильтр: none, moderate, strict
+}
+
+func (t *RankTracking) searchOptions() SearchOptions {
+	return SearchOptions{Pages: t.Pages, Hosts: t.Hosts, Region: t.Region, Lang: t.Lang, Family: t.Family}
+}
+
+// enabled отслеживание включено, если заданы домены и запросы
+func (t *RankTracking) enabled() bool {
+	return len(t.Domains) > 0 && len(t.Keywords) > 0
+}
+
+// validate проверяет отслеживание мест по конфигурации cfg, как мониторы
+func (t *RankTracking) validate(cfg *Config) error {
+	if len(t.Domains) == 0 && len(t.Keywords) == 0 {
+		return nil
+	}
+	if !t.enabled() {
+		return fmt.Errorf("both domains and keywords must be set")
+	}
+	for _, d := range t.Domains {
+		if h := normalizeHost(d); h == "" || strings.ContainsAny(h, "/:? ") {
+			return fmt.Errorf("bad domain %q", d)
+		}
+	}
+	for _, k := range t.Keywords {
+		if strings.TrimSpace(k) == "" {
+			return fmt.Errorf("empty keyword")
+		}
+	}
+	if t.Provider != "" {
+		if _, ok := searchProviders[strings.ToLower(t.Provider)]; !ok {
+			return fmt.Errorf("unknown search provider %q", t.Provider)
+		}
+	}
+	if (t.Interval == 0) == (t.Cron == "") {
+		return fmt.Errorf("either interval or cron must be set")
+	}
+	if t.Interval != 0 && t.Interval < minMonitorInterval {
+		return fmt.Errorf("interval %d is less than %d", t.Interval, minMonitorInterval)
+	}
+	if err := validateSchedule(t.Interval, t.Jitter); err != nil {
+		return err
+	}
+	if t.Cron != "" {
+		if _, err := parseCron(t.Cron); err != nil {
+			return err
+		}
+	}
+	if t.Count > maxCountRequest {
+		return fmt.Errorf("count %d is more than %d", t.Count, maxCountRequest)
+	}
+	if timeOutWork := cfg.timeOutWork(); t.TimeOut > uint64(timeOutWork/time.Millisecond) {
+		return fmt.Errorf("timeout %dms is more than TimeOutWork %v", t.TimeOut, timeOutWork)
+	}
+	if err := t.Mode.validate(); err != nil {
+		return err
+	}
+	return t.searchOptions().validate()
+}
+
+func (t *RankTracking) settings() probeSettings {
+	spec := checkSpec{Count: t.Count, TimeOut: t.TimeOut, Mode: t.Mode}
+	return spec.settings()
+}
+
+// rankRecord место домена по запросу в одном из запусков
+type rankRecord struct {
+	RunID    string    `json:",omitempty"`
+	Time     time.Time `json:",omitempty"`
+	Keyword  string
+	Domain   string
+	Position int           // место среди обычных результатов, 0 - нет на загруженных страницах
+	Url      string        `json:",omitempty"` // адрес, по которому домен занимает место
+	Title    string        `json:",omitempty"`
+	Check    *ResponseData `json:",omitempty"` // доступность адреса
+	Error    string        `json:",omitempty"` // ошибка поиска
+}
+
+// rankRun запуск отслеживания мест
+type rankRun struct {
+	ID       string
+	Time     time.Time
+	Duration time.Duration
+	Provider string
+	Records  []rankRecord
+}
+
+// RankChange текущее место домена по запросу и его изменение с предыдущего запуска
+type RankChange struct {
+	Keyword      string
+	Domain       string
+	Position     int
+	Previous     int           `json:",omitempty"`
+	Change       int           // на сколько мест поднялся домен, отрицательное - опустился
+	Status       string        // new, lost, up, down, same; пусто - нет предыдущего запуска
+	Url          string        `json:",omitempty"`
+	Title        string        `json:",omitempty"`
+	Time         time.Time     // время текущего запуска
+	PreviousTime *time.Time    `json:",omitempty"`
+	Check        *ResponseData `json:",omitempty"`
+	Error        string        `json:",omitempty"`
+}
+
+func rankChange(cur rankRecord, prev *rankRecord) RankChange {
+	c := RankChange{Keyword: cur.Keyword, Domain: cur.Domain, Position: cur.Position, Url: cur.Url, Title: cur.Title,
+		Time: cur.Time, Check: cur.Check, Error: cur.Error}
+	if prev == nil || cur.Error != "" || prev.Error != "" {
+		return c
+	}
+	prevTime := prev.Time
+	c.Previous = prev.Position
+	c.PreviousTime = &prevTime
+	switch {
+	case cur.Position == prev.Position:
+		c.Status = "same"
+	case prev.Position == 0:
+		c.Status = "new"
+	case cur.Position == 0:
+		c.Status = "lost"
+	default:
+		c.Change = prev.Position - cur.Position
+		c.Status = "up"
+		if c.Change < 0 {
+			c.Status = "down"
+		}
+	}
+	return c
+}
+
+// rankItem первый обычный результат выдачи на домене или его поддомене
+func rankItem(items []responseItem, domain string) (responseItem, bool) {
+	domain = normalizeHost(domain)
+	for _, item := range items {
+		if item.Type != SerpOrganic {
+			continue
+		}
+		u, err := url.Parse(item.Url)
+		if err != nil {
+			continue
+		}
+		if h := normalizeHost(u.Host); h == domain || strings.HasSuffix(h, "."+domain) {
+			return item, true
+		}
+	}
+	return responseItem{}, false
+}
+
+// rankTracker запускает отслеживание мест по расписанию из config.yaml
+type rankTracker struct {
+	mu        sync.Mutex
+	config    RankTracking
+	stop      chan struct{}
+	running   bool
+	lastRun   time.Time
+	lastError string
+	nextRun   time.Time
+	closed    bool           // сервис останавливается, новые запуски не начинаются
+	runs      sync.WaitGroup // расписание и запуски через POST /ranks
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+var ranks *rankTracker
+
+func newRankTracker() *rankTracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &rankTracker{ctx: ctx, cancel: cancel}
+}
+
+// shutdown останавливает расписание и ждет завершения запуска, по истечении ctx запуск отменяется
+func (t *rankTracker) shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	t.closed = true
+	if t.stop != nil {
+		close(t.stop)
+		t.stop = nil
+	}
+	t.mu.Unlock()
+	return waitOrCancel(ctx, &t.runs, t.cancel)
+}
+
+// start запускает fn в отдельной горутине, если сервис не останавливается
+func (t *rankTracker) start(fn func()) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	t.runs.Add(1)
+	go func() {
+		defer t.runs.Done()
+		fn()
+	}()
+	return true
+}
+
+// set применяет настройки RankTracking из config.yaml, при изменении перезапускает расписание
+func (t *rankTracker) set(cfg RankTracking) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	if t.stop != nil {
+		ja, _ := json.Marshal(t.config)
+		jb, _ := json.Marshal(cfg)
+		if string(ja) == string(jb) {
+			return
+		}
+		close(t.stop)
+		t.stop = nil
+	}
+	t.config = cfg
+	t.nextRun = time.Time{}
+	if !cfg.enabled() {
+		return
+	}
+	if history == nil {
+		logWarn(context.Background(), "Отслеживание мест не запущено: не задан HistoryFile")
+		return
+	}
+	if t.lastRun.IsZero() {
+		last, err := history.lastRankRun()
+		if err != nil {
+			logError(context.Background(), "Ошибка чтения истории мест", "error", err)
+		}
+		t.lastRun = last
+	}
+	var schedule *cronSchedule
+	if cfg.Cron != "" {
+		schedule, _ = parseCron(cfg.Cron)
+	}
+	stop := make(chan struct{})
+	t.stop = stop
+	t.runs.Add(1)
+	go func() {
+		defer t.runs.Done()
+		t.loop(cfg, schedule, stop)
+	}()
+}
+
+func (t *rankTracker) loop(cfg RankTracking, schedule *cronSchedule, stop chan struct{}) {
+	for {
+		t.mu.Lock()
+		next := nextScheduledRun(time.Now(), t.lastRun, schedule, cfg.Interval, cfg.Jitter)
+		t.nextRun = next
+		t.mu.Unlock()
+		if next.IsZero() {
+			logWarn(context.Background(), "Расписание отслеживания мест не наступит, отслеживание остановлено", "cron", cfg.Cron)
+			<-stop
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := t.run(cfg); err != nil {
+			logError(context.Background(), "Ошибка отслеживания мест", "error", err)
+		}
+	}
+}
+
+// run выполняет один запуск: места доменов по каждому запросу и доступность найденных адресов
+func (t *rankTracker) run(cfg RankTracking) error {
+	t.mu.Lock()
+	if t.running {
+		t.mu.Unlock()
+		return fmt.Errorf("rank tracking is already running")
+	}
+	t.running = true
+	t.mu.Unlock()
+
+	start := time.Now()
+	err := executeRankRun(t.ctx, cfg, start)
+
+	t.mu.Lock()
+	t.running = false
+	t.lastRun = start
+	t.lastError = ""
+	if err != nil {
+		t.lastError = err.Error()
+	}
+	t.mu.Unlock()
+	return err
+}
+
+func executeRankRun(parent context.Context, cfg RankTracking, start time.Time) error {
+	provider, err := getSearchProvider(cfg.Provider)
+	if err != nil {
+		return err
+	}
+	timeOutWork := currentConfig().timeOutWork()
+
+	run := &rankRun{Time: start, Provider: provider.Name()}
+	var found []responseItem
+	for _, keyword := range cfg.Keywords {
+		// каждый запрос к поисковику ограничен TimeOutWork, как поиск в /sites
+		searchCtx, cancel := context.WithTimeout(parent, timeOutWork)
+		items, err := searchItems(searchCtx, provider.Name(), keyword, cfg.searchOptions())
+		cancel()
+		for _, domain := range cfg.Domains {
+			rec := rankRecord{Keyword: keyword, Domain: domain}
+			if err != nil {
+				rec.Error = err.Error()
+			} else if item, ok := rankItem(items, domain); ok {
+				rec.Position = item.Position
+				rec.Url = item.Url
+				rec.Title = item.Title
+				found = append(found, responseItem{Host: item.Url, Url: item.Url})
+			}
+			run.Records = append(run.Records, rec)
+		}
+	}
+
+	// время проверки найденных адресов отсчитывается после загрузки всей выдачи
+	ctx, cancel := context.WithTimeout(parent, timeOutWork)
+	defer cancel()
+	results := checkSites(ctx, found, 0, cfg.settings(), nil)
+	for i := range run.Records {
+		if data, ok := results[run.Records[i].Url]; ok {
+			data := data
+			run.Records[i].Check = &data
+		}
+	}
+	run.Duration = time.Since(start)
+	return history.saveRankRun(run)
+}
+
+// RankTrackerView состояние отслеживания мест для ответа API
+type RankTrackerView struct {
+	Config    RankTracking
+	Running   bool
+	LastRun   *time.Time `json:",omitempty"`
+	LastError string     `json:",omitempty"`
+	NextRun   *time.Time `json:",omitempty"`
+	Ranks     []RankChange
+}
+
+func (t *rankTracker) view() RankTrackerView {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	v := RankTrackerView{Config: t.config, Running: t.running, LastError: t.lastError}
+	if !t.lastRun.IsZero() {
+		lastRun := t.lastRun
+		v.LastRun = &lastRun
+	}
+	if !t.nextRun.IsZero() {
+		nextRun := t.nextRun
+		v.NextRun = &nextRun
+	}
+	return v
+}
+
+func (h *historyStore) saveRankRun(run *rankRun) error {
+	return h.db.Update(func(tx *bolt.Tx) error {
+		runs := tx.Bucket(rankRunsBucket)
+		seq, err := runs.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := runKey(run.Time, seq)
+		run.ID = hex.EncodeToString(key)
+		b, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		return runs.Put(key, b)
+	})
+}
+
+func (h *historyStore) lastRankRun() (time.Time, error) {
+	var last time.Time
+	err := h.db.View(func(tx *bolt.Tx) error {
+		_, v := tx.Bucket(rankRunsBucket).Cursor().Last()
+		if v == nil {
+			return nil
+		}
+		var run rankRun
+		if err := json.Unmarshal(v, &run); err != nil {
+			return err
+		}
+		last = run.Time
+		return nil
+	})
+	return last, err
+}
+
+// scanRankRuns перебирает запуски в интервале [f.From, f.To] от новых к старым
+func (h *historyStore) scanRankRuns(f historyFilter, fn func(run *rankRun) bool) error {
+	return h.db.View(func(tx *bolt.Tx) error {
+		var err error
+		scan(tx.Bucket(rankRunsBucket).Cursor(), f, func(k, v []byte) bool {
+			var run rankRun
+			if err = json.Unmarshal(v, &run); err != nil {
+				return false
+			}
+			return fn(&run)
+		})
+		return err
+	})
+}
+
+// rankHistory места по запросу keyword и домену domain (пустые - все), от новых к старым
+func (h *historyStore) rankHistory(keyword, domain string, f historyFilter) ([]rankRecord, error) {
+	res := make([]rankRecord, 0)
+	err := h.scanRankRuns(f, func(run *rankRun) bool {
+		if f.Provider != "" && f.Provider != run.Provider {
+			return true
+		}
+		for _, rec := range run.Records {
+			if (keyword == "" || keyword == rec.Keyword) && (domain == "" || domain == rec.Domain) {
+				rec.RunID = run.ID
+				rec.Time = run.Time
+				res = append(res, rec)
+			}
+		}
+		return len(res) < f.Limit
+	})
+	return res, err
+}
+
+// rankChanges места последнего запуска и их изменение по сравнению с предыдущим запуском, где есть та же пара запрос-домен
+func (h *historyStore) rankChanges() ([]RankChange, error) {
+	type pair struct{ keyword, domain string }
+	var current []rankRecord
+	prev := make(map[pair]*rankRecord)
+	runs := 0
+	err := h.scanRankRuns(historyFilter{}, func(run *rankRun) bool {
+		runs++
+		for _, rec := range run.Records {
+			rec := rec
+			rec.RunID = run.ID
+			rec.Time = run.Time
+			if runs == 1 {
+				current = append(current, rec)
+				continue
+			}
+			key := pair{rec.Keyword, rec.Domain}
+			if _, ok := prev[key]; !ok {
+				prev[key] = &rec
+			}
+		}
+		return len(prev) < len(current) && runs < historyMaxLimit
+	})
+	res := make([]RankChange, 0, len(current))
+	for _, rec := range current {
+		res = append(res, rankChange(rec, prev[pair{rec.Keyword, rec.Domain}]))
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if res[i].Keyword != res[j].Keyword {
+			return res[i].Keyword < res[j].Keyword
+		}
+		return res[i].Domain < res[j].Domain
+	})
+	return res, err
+}
+
+// ranksHandler GET /ranks - состояние отслеживания и текущие места с изменением, POST /ranks - запустить отслеживание сейчас
+func ranksHandler(w http.ResponseWriter, r *http.Request) {
+	if history == nil {
+		http.Error(w, "history is disabled", 404)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		v := ranks.view()
+		var err error
+		if v.Ranks, err = history.rankChanges(); err != nil {
+			logError(r.Context(), "Ошибка чтения истории мест", "error", err)
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+		writeJSON(w, 200, v)
+	case http.MethodPost:
+		ranks.mu.Lock()
+		cfg, running := ranks.config, ranks.running
+		ranks.mu.Unlock()
+		if !cfg.enabled() {
+			http.Error(w, "rank tracking is not configured", 409)
+			return
+		}
+		if running {
+			http.Error(w, "rank tracking is already running", 409)
+			return
+		}
+		started := ranks.start(func() {
+			if err := ranks.run(cfg); err != nil {
+				logError(context.Background(), "Ошибка отслеживания мест", "error", err)
+			}
+		})
+		if !started {
+			http.Error(w, errShuttingDown.Error(), 503)
+			return
+		}
+		w.WriteHeader(202)
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(w, http.StatusText(405), 405)
+	}
+}
+
+// rankHistoryHandler GET /ranks/history - история мест.
+// Параметры: keyword, domain, provider, from и to в RFC3339, limit.
+func rankHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(405), 405)
+		return
+	}
+	if history == nil {
+		http.Error(w, "history is disabled", 404)
+		return
+	}
+	f, err := parseHistoryFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	res, err := history.rankHistory(r.URL.Query().Get("keyword"), r.URL.Query().Get("domain"), f)
+	if err != nil {
+		logError(r.Context(), "Ошибка чтения истории мест", "error", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	writeJSON(w, 200, res)
+}
+
+// RanksPage данные страницы /ranksclient
+type RanksPage struct {
+	Tracker RankTrackerView
+	Keyword string
+	Domain  string
+	History []rankRecord // история пары запрос-домен, если они заданы
+}
+
+// ranksClientHandler GET /ranksclient - страница с текущими местами,
+// с параметрами keyword и domain - история мест домена по запросу
+func ranksClientHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(405), 405)
+		return
+	}
+	if history == nil {
+		http.Error(w, "history is disabled", 404)
+		return
+	}
+	page := RanksPage{Tracker: ranks.view(), Keyword: r.URL.Query().Get("keyword"), Domain: r.URL.Query().Get("domain")}
+	var err error
+	if page.Tracker.Ranks, err = history.rankChanges(); err == nil && page.Keyword != "" && page.Domain != "" {
+		page.History, err = history.rankHistory(page.Keyword, page.Domain, historyFilter{Limit: historyMaxLimit})
+	}
+	if err != nil {
+		logError(r.Context(), "Ошибка чтения истории мест", "error", err)
+		http.Error(w, http.StatusText(500), 500)
+		return
+	}
+	renderTemplate(r.Context(), w, "ranks.html", &page)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit ограничения частоты запросов к проверяемым сайтам, общие для всех проверок
+type RateLimit struct {
+	HostRPS       float64 // запросов в секунду к одному хосту, 0 - без ограничения
+	HostBurst     int     // запросов к хосту подряд без ожидания, 0 - 1
+	HostSpacing   uint64  // минимальный интервал между запросами к одному хосту в миллисекундах, 0 - без интервала
+	GlobalRPS     float64 // запросов в секунду ко всем сайтам, 0 - без ограничения
+	GlobalBurst   int     // запросов подряд без ожидания, 0 - 1
+	MaxRetryAfter uint64  // максимальная пауза по Retry-After ответов 429 и 503 в миллисекундах, 0 - Retry-After не учитывается
+}
+
+func (r RateLimit) validate() error {
+	switch {
+	case r.HostRPS < 0 || r.GlobalRPS < 0:
+		return fmt.Errorf("HostRPS and GlobalRPS must not be negative")
+	case r.HostBurst < 0 || r.GlobalBurst < 0:
+		return fmt.Errorf("HostBurst and GlobalBurst must not be negative")
+	}
+	return nil
+}
+
+// tokenBucket корзина токенов: rate токенов в секунду, не больше burst.
+// Токены резервируются заранее, отрицательный остаток - очередь ожидающих запросов.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// reserve забирает токен для запроса, который будет отправлен не раньше at, и возвращает время отправки.
+// Резервирования с at раньше предыдущего не пополняют корзину, ожидание считается от предыдущего.
+func (b *tokenBucket) reserve(at time.Time) time.Time {
+	if b.rate <= 0 {
+		return at
+	}
+	if b.last.IsZero() || at.After(b.last) {
+		if !b.last.IsZero() {
+			b.tokens += at.Sub(b.last).Seconds() * b.rate
+			if b.tokens > b.burst {
+				b.tokens = b.burst
+			}
+		}
+		b.last = at
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return at
+	}
+	if start := b.last.Add(time.Duration(-b.tokens / b.rate * float64(time.Second))); start.After(at) {
+		return start
+	}
+	return at
+}
+
+// cancel возвращает токен запроса, который не будет отправлен
+func (b *tokenBucket) cancel() {
+	if b.rate > 0 {
+		b.tokens++
+	}
+}
+
+// hostLimit состояние ограничений одного хоста
+type hostLimit struct {
+	bucket  tokenBucket
+	next    time.Time // запросы не раньше: интервал HostSpacing и Retry-After
+	lastUse time.Time
+}
+
+// probeLimiter ограничивает запросы проверок к сайтам по хостам и в целом
+type probeLimiter struct {
+	mu       sync.Mutex
+	settings RateLimit
+	global   tokenBucket
+	hosts    map[string]*hostLimit
+	cleaned  time.Time
+}
+
+var probeLimits = &probeLimiter{hosts: make(map[string]*hostLimit)}
+
+const hostLimitIdle = 10 * time.Minute // состояние хоста без запросов столько времени удаляется
+
+// set применяет настройки из config.yaml, при изменении накопленное состояние сбрасывается
+func (l *probeLimiter) set(r RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.settings == r && l.global.burst > 0 {
+		return
+	}
+	l.settings = r
+	l.global = newTokenBucket(r.GlobalRPS, r.GlobalBurst)
+	l.hosts = make(map[string]*hostLimit)
+}
+
+func (l *probeLimiter) hostLocked(host string, now time.Time) *hostLimit {
+	if now.Sub(l.cleaned) > time.Minute {
+		for name, h := range l.hosts {
+			if now.Sub(h.lastUse) > hostLimitIdle && now.After(h.next) {
+				delete(l.hosts, name)
+			}
+		}
+		l.cleaned = now
+	}
+	h, ok := l.hosts[host]
+	if !ok {
+		h = &hostLimit{bucket: newTokenBucket(l.settings.HostRPS, l.settings.HostBurst)}
+		l.hosts[host] = h
+	}
+	return h
+}
+
+// rateLimitError запрос не может быть отправлен до истечения времени ctx
+type rateLimitError struct {
+	host  string
+	until time.Time
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("host %s is rate limited until %s, after the request deadline", e.host, e.until.Format(time.RFC3339))
+}
+
+// wait ждет разрешения на запрос к host. Если разрешение наступит позже срока ctx, сразу возвращает *rateLimitError.
+// Сначала ожидается время, разрешенное ограничениями хоста, и только затем резервируется общий токен:
+// запросы, отложенные ограничением хоста, не отправляются разом сверх GlobalRPS и не занимают
+// общую очередь заранее, задерживая запросы к другим хостам.
+func (l *probeLimiter) wait(ctx context.Context, host string) (time.Duration, error) {
+	begin := time.Now()
+	l.mu.Lock()
+	h := l.hostLocked(host, begin)
+	start := h.bucket.reserve(begin)
+	if h.next.After(start) {
+		start = h.next
+	}
+	if deadline, ok := ctx.Deadline(); ok && start.After(deadline) {
+		h.bucket.cancel()
+		l.mu.Unlock()
+		return 0, &rateLimitError{host, start}
+	}
+	l.reserveSpacingLocked(h, start)
+	l.mu.Unlock()
+	if err := sleepUntil(ctx, start); err != nil {
+		return l.waited(begin), err
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	global := l.global.reserve(now)
+	if deadline, ok := ctx.Deadline(); ok && global.After(deadline) {
+		l.global.cancel()
+		l.mu.Unlock()
+		return l.waited(begin), &rateLimitError{host, global}
+	}
+	if global.After(start) {
+		// токен хоста расходуется в момент фактической отправки
+		h.bucket.cancel()
+		h.bucket.reserve(global)
+		l.reserveSpacingLocked(h, global)
+	}
+	l.mu.Unlock()
+	if !start.After(begin) && !global.After(now) {
+		return 0, nil
+	}
+	err := sleepUntil(ctx, global)
+	return l.waited(begin), err
+}
+
+func (l *probeLimiter) reserveSpacingLocked(h *hostLimit, start time.Time) {
+	if l.settings.HostSpacing > 0 {
+		if next := start.Add(time.Millisecond * time.Duration(l.settings.HostSpacing)); next.After(h.next) {
+			h.next = next
+		}
+	}
+	h.lastUse = start
+}
+
+// waited время ожидания с begin, учитывается в метрике
+func (l *probeLimiter) waited(begin time.Time) time.Duration {
+	d := time.Since(begin)
+	probeRateLimitWait.Add(d.Seconds())
+	return d
+}
+
+func sleepUntil(ctx context.Context, t time.Time) error {
+	d := time.Until(t)
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// delay откладывает запросы к host на d по Retry-After, пауза ограничена MaxRetryAfter
+func (l *probeLimiter) delay(host string, d time.Duration) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if max := time.Millisecond * time.Duration(l.settings.MaxRetryAfter); d > max {
+		d = max
+	}
+	if d <= 0 {
+		return 0
+	}
+	now := time.Now()
+	h := l.hostLocked(host, now)
+	if until := now.Add(d); until.After(h.next) {
+		h.next = until
+	}
+	return d
+}
+
+// limitHost ключ ограничений - имя хоста адреса
+func limitHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// retryAfter пауза из заголовка Retry-After ответа 429 или 503: секунды или HTTP дата.
+// Дата указывается с точностью до секунды, поэтому пауза до нее округляется вверх до целых секунд.
+func retryAfter(resp *http.Response, now time.Time) time.Duration {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+	v := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if v == "" {
+		return 0
+	}
+	if sec, err := strconv.Atoi(v); err == nil && sec >= 0 {
+		return time.Duration(sec) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return (t.Sub(now) + time.Second - 1).Truncate(time.Second)
+	}
+	return 0
+}
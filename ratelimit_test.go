@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketReserve(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	b := newTokenBucket(2, 3)
+	reserve := func(at time.Time, want time.Duration) {
+		t.Helper()
+		if got := b.reserve(at).Sub(t0); got != want {
+			t.Errorf("reserve(t0+%v) = t0+%v, want t0+%v", at.Sub(t0), got, want)
+		}
+	}
+
+	// burst запросов без ожидания, дальше - по одному на 1/rate секунды
+	reserve(t0, 0)
+	reserve(t0, 0)
+	reserve(t0, 0)
+	reserve(t0, 500*time.Millisecond)
+	reserve(t0, time.Second)
+	// резервирование с более ранним временем не пополняет корзину
+	reserve(t0.Add(-time.Second), 1500*time.Millisecond)
+	b.cancel()
+
+	// за 10 секунд корзина пополняется, но не больше burst
+	at := t0.Add(10 * time.Second)
+	reserve(at, 10*time.Second)
+	reserve(at, 10*time.Second)
+	reserve(at, 10*time.Second)
+	reserve(at, 10500*time.Millisecond)
+
+	// за секунду погашена очередь и накоплен один токен
+	reserve(at.Add(time.Second), 11*time.Second)
+	reserve(at.Add(time.Second), 11500*time.Millisecond)
+
+	unlimited := newTokenBucket(0, 0)
+	for i := 0; i < 10; i++ {
+		if got := unlimited.reserve(t0); !got.Equal(t0) {
+			t.Fatalf("unlimited bucket: got t0+%v", got.Sub(t0))
+		}
+	}
+}
+
+func newTestLimiter(r RateLimit) *probeLimiter {
+	l := &probeLimiter{hosts: make(map[string]*hostLimit)}
+	l.set(r)
+	return l
+}
+
+func TestProbeLimiterWait(t *testing.T) {
+	const interval = 50 * time.Millisecond // 20 запросов в секунду
+	ctx := context.Background()
+	wait := func(l *probeLimiter, host string, min, max time.Duration) {
+		t.Helper()
+		begin := time.Now()
+		d, err := l.wait(ctx, host)
+		if err != nil {
+			t.Fatalf("%s: %v", host, err)
+		}
+		if elapsed := time.Since(begin); elapsed < min || elapsed > max || d > elapsed {
+			t.Errorf("%s: waited %v (reported %v), want %v..%v", host, elapsed, d, min, max)
+		}
+	}
+
+	t.Run("host", func(t *testing.T) {
+		l := newTestLimiter(RateLimit{HostRPS: 20, HostBurst: 2})
+		wait(l, "a", 0, interval/2)
+		wait(l, "a", 0, interval/2)
+		wait(l, "a", interval*3/4, 3*interval) // burst исчерпан
+		wait(l, "b", 0, interval/2)            // другие хосты не ждут
+		time.Sleep(2 * interval)
+		wait(l, "a", 0, interval/2) // токен пополнился
+	})
+
+	t.Run("global", func(t *testing.T) {
+		l := newTestLimiter(RateLimit{GlobalRPS: 20, GlobalBurst: 1})
+		wait(l, "a", 0, interval/2)
+		wait(l, "b", interval*3/4, 3*interval)
+	})
+
+	t.Run("spacing", func(t *testing.T) {
+		l := newTestLimiter(RateLimit{HostSpacing: uint64(interval / time.Millisecond)})
+		wait(l, "a", 0, interval/2)
+		wait(l, "a", interval*3/4, 3*interval)
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		l := newTestLimiter(RateLimit{HostRPS: 10})
+		wait(l, "a", 0, interval/2)
+		short, cancel := context.WithTimeout(ctx, interval)
+		defer cancel()
+		begin := time.Now()
+		_, err := l.wait(short, "a")
+		var rl *rateLimitError
+		if !errors.As(err, &rl) || time.Since(begin) > interval/2 {
+			t.Errorf("got %v after %v, want immediate *rateLimitError", err, time.Since(begin))
+		}
+		// отклоненный запрос не занимает токен
+		time.Sleep(3 * interval)
+		wait(l, "a", 0, interval/2)
+	})
+
+	t.Run("retry after", func(t *testing.T) {
+		l := newTestLimiter(RateLimit{MaxRetryAfter: uint64(interval / time.Millisecond)})
+		if d := l.delay("a", time.Hour); d != interval {
+			t.Errorf("delay = %v, want MaxRetryAfter %v", d, interval)
+		}
+		wait(l, "a", interval*3/4, 3*interval)
+		wait(l, "b", 0, interval/2)
+	})
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 300*int(time.Millisecond), time.UTC)
+	tests := []struct {
+		status int
+		header string
+		want   time.Duration
+	}{
+		{http.StatusTooManyRequests, "3", 3 * time.Second},
+		{http.StatusServiceUnavailable, " 0 ", 0},
+		{http.StatusTooManyRequests, "Mon, 01 Jan 2024 12:00:05 GMT", 5 * time.Second}, // 4.7s округляется вверх
+		{http.StatusServiceUnavailable, "Mon, 01 Jan 2024 12:00:01 GMT", time.Second},
+		{http.StatusTooManyRequests, "Mon, 01 Jan 2024 12:00:00 GMT", 0}, // дата в прошлом
+		{http.StatusTooManyRequests, "-5", 0},
+		{http.StatusTooManyRequests, "soon", 0},
+		{http.StatusTooManyRequests, "", 0},
+		{http.StatusOK, "3", 0},
+		{http.StatusInternalServerError, "3", 0},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+		if tt.header != "" {
+			resp.Header.Set("Retry-After", tt.header)
+		}
+		if got := retryAfter(resp, now); got != tt.want {
+			t.Errorf("%d Retry-After %q: got %v, want %v", tt.status, tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// SerpKind результат разбора страницы выдачи
+type SerpKind string
+
+const (
+	SerpResults      SerpKind = "results"      // выдача с результатами
+	SerpEmpty        SerpKind = "empty"        // выдача без результатов
+	SerpCaptcha      SerpKind = "captcha"      // проверка на робота или блокировка
+	SerpUnrecognized SerpKind = "unrecognized" // неизвестная разметка
+	SerpFetchError   SerpKind = "fetch_error"  // страницу не удалось загрузить
+)
+
+const maxSerpDumps = 100 // сколько последних сохраненных страниц хранить в SerpDumpDir
+
+// SearchError ошибка получения выдачи поисковика
+type SearchError struct {
+	Provider string
+	Kind     SerpKind
+	Page     int
+	Err      error
+	RawPage  string // имя файла с сохраненной страницей выдачи в SerpDumpDir, без каталога
+}
+
+func (e *SearchError) Error() string {
+	return fmt.Sprintf("%s search %s on page %d: %v", e.Provider, e.Kind, e.Page, e.Err)
+}
+
+func (e *SearchError) Unwrap() error {
+	return e.Err
+}
+
+// httpStatus код ответа /sites при ошибке поиска
+func (e *SearchError) httpStatus() int {
+	if e.Kind == SerpCaptcha {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusBadGateway
+}
+
+// searchErrorBody тело ответа с ошибкой поиска
+type searchErrorBody struct {
+	Error    SerpKind
+	Message  string
+	Provider string
+	Page     int
+	RawPage  string `json:",omitempty"`
+}
+
+func writeSearchError(w http.ResponseWriter, e *SearchError) {
+	if e.Kind == SerpCaptcha {
+		w.Header().Set("Retry-After", "60")
+	}
+	writeJSON(w, e.httpStatus(), searchErrorBody{
+		Error:    e.Kind,
+		Message:  e.Error(),
+		Provider: e.Provider,
+		Page:     e.Page,
+		RawPage:  e.RawPage,
+	})
+}
+
+// dumpSerpPage сохраняет страницу выдачи в SerpDumpDir для разбора и возвращает имя файла без каталога:
+// оно попадает в ответ клиенту, полный путь на сервере пишется только в журнал
+func dumpSerpPage(provider string, kind SerpKind, page int, body []byte) string {
+	dir := currentConfig().SerpDumpDir
+	if dir == "" || len(body) == 0 {
+		return ""
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logError(context.Background(), "Ошибка сохранения страницы выдачи", "error", err)
+		return ""
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%s-%s-p%d-%s.html", provider, time.Now().Format("20060102-150405.000"), page, kind))
+	if err := ioutil.WriteFile(name, body, 0644); err != nil {
+		logError(context.Background(), "Ошибка сохранения страницы выдачи", "error", err)
+		return ""
+	}
+	logWarn(context.Background(), "Сохранена страница выдачи", "provider", provider, "kind", kind, "file", name)
+	pruneSerpDumps(dir)
+	return filepath.Base(name)
+}
+
+var serpDumpsPruning int32
+
+// pruneSerpDumps удаляет старые страницы, оставляя maxSerpDumps последних
+func pruneSerpDumps(dir string) {
+	if !atomic.CompareAndSwapInt32(&serpDumpsPruning, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&serpDumpsPruning, 0)
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil || len(files) <= maxSerpDumps {
+		return
+	}
+	sort.Slice(files, func(i, j int) bool {
+		fi, _ := os.Stat(files[i])
+		fj, _ := os.Stat(files[j])
+		return fi != nil && fj != nil && fi.ModTime().Before(fj.ModTime())
+	})
+	for _, f := range files[:len(files)-maxSerpDumps] {
+		os.Remove(f)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/spf13/viper"
+)
+
+// setTestConfig делает действующей конфигурацию из config.yaml репозитория, измененную edit
+func setTestConfig(t *testing.T, edit func(cfg *Config)) {
+	t.Helper()
+	setConfigDefaults()
+	viper.SetConfigFile("config.yaml")
+	cfg, err := readConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edit != nil {
+		edit(cfg)
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatal(err)
+	}
+	applyConfig(cfg)
+}
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+const unrecognizedPage = "<html><body><p>Сайт на реконструкции</p></body></html>"
+
+func TestClassifyYandexPage(t *testing.T) {
+	tests := []struct {
+		name string
+		body []byte
+		want SerpKind
+	}{
+		{"results", readTestdata(t, "yandex.html"), SerpResults},
+		{"empty", readTestdata(t, "yandex-empty.html"), SerpEmpty},
+		{"captcha", readTestdata(t, "yandex-captcha.html"), SerpCaptcha},
+		{"unrecognized", []byte(unrecognizedPage), SerpUnrecognized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if res := parseYandexResponse(tt.body); res.Kind != tt.want {
+				t.Errorf("got kind %q, want %q", res.Kind, tt.want)
+			}
+		})
+	}
+
+	// выдача, в которой все результаты отфильтрованы, - пустая, а не неизвестная страница
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader([]byte(unrecognizedPage)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind := classifyYandexPage([]byte(unrecognizedPage), doc, 3, 0); kind != SerpEmpty {
+		t.Errorf("filtered serp: got kind %q, want %q", kind, SerpEmpty)
+	}
+}
+
+// useFakeYandex направляет запросы к поисковикам на сервер, отвечающий status и body
+func useFakeYandex(t *testing.T, status int, body []byte) {
+	t.Helper()
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	addr := srv.Listener.Addr().String()
+	transports.mu.Lock()
+	transports.pooled = &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	transports.mu.Unlock()
+}
+
+func TestSearchSitesYandexErrors(t *testing.T) {
+	dumpDir := t.TempDir()
+	setTestConfig(t, func(cfg *Config) {
+		cfg.SearchProvider = "yandex"
+		cfg.SerpDumpDir = dumpDir
+	})
+	tests := []struct {
+		name       string
+		status     int
+		body       []byte
+		wantStatus int
+		wantKind   SerpKind
+		wantDump   bool
+	}{
+		{"captcha", 200, readTestdata(t, "yandex-captcha.html"), 503, SerpCaptcha, true},
+		{"unrecognized", 200, []byte(unrecognizedPage), 502, SerpUnrecognized, true},
+		{"fetch error", 500, []byte("internal error"), 502, SerpFetchError, false},
+		{"empty", 200, readTestdata(t, "yandex-empty.html"), 200, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeYandex(t, tt.status, tt.body)
+			w := httptest.NewRecorder()
+			searchSites(w, httptest.NewRequest(http.MethodGet, "/sites?search=golang", nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantKind == "" {
+				var res map[string]ResponseData
+				if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || len(res) != 0 {
+					t.Errorf("got %s, want empty map", w.Body.String())
+				}
+				return
+			}
+			var body searchErrorBody
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("bad error body %s: %v", w.Body.String(), err)
+			}
+			if body.Error != tt.wantKind || body.Provider != "yandex" {
+				t.Errorf("got error %q from %q, want %q from yandex", body.Error, body.Provider, tt.wantKind)
+			}
+			if (body.RawPage != "") != tt.wantDump {
+				t.Errorf("got RawPage %q, want saved page: %v", body.RawPage, tt.wantDump)
+			}
+			if tt.wantDump {
+				// в ответе только имя файла, путь на сервере не раскрывается
+				if body.RawPage != filepath.Base(body.RawPage) || strings.Contains(w.Body.String(), dumpDir) {
+					t.Errorf("RawPage %q exposes the dump path %s", body.RawPage, dumpDir)
+				}
+				if _, err := os.Stat(filepath.Join(dumpDir, body.RawPage)); err != nil {
+					t.Errorf("saved page: %v", err)
+				}
+			}
+			if tt.wantKind == SerpCaptcha && w.Header().Get("Retry-After") == "" {
+				t.Error("captcha response without Retry-After")
+			}
+		})
+	}
+}
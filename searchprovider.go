@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// SearchProvider источник поисковой выдачи: по строке запроса возвращает список сайтов
+type SearchProvider interface {
+	Name() string
+	Endpoint() string // адрес для проверки доступности поисковика
+	Search(ctx context.Context, query string, opts SearchOptions) responseStruct
+}
+
+// SearchOptions параметры выдачи Яндекса, нулевые значения берутся из config.yaml
+type SearchOptions struct {
+	Pages  int    `json:"pages,omitempty"`  // максимум загружаемых страниц выдачи
+	Hosts  int    `json:"hosts,omitempty"`  // прекратить загрузку страниц, набрав столько различных сайтов, 0 - без ограничения
+	Region int    `json:"region,omitempty"` // код региона (lr), 213 - Москва
+	Lang   string `json:"lang,omitempty"`   // язык выдачи
+	Family string `json:"family,omitempty"` // семейный фильтр: none, moderate, strict
+}
+
+var searchFamilyFilters = map[string]struct{}{"": {}, "none": {}, "moderate": {}, "strict": {}}
+
+func (o SearchOptions) validate() error {
+	if o.Pages < 0 || o.Pages > maxSearchPages {
+		return fmt.Errorf("bad pages value %d, expected 1-%d", o.Pages, maxSearchPages)
+	}
+	if o.Hosts < 0 {
+		return fmt.Errorf("bad hosts value %d", o.Hosts)
+	}
+	if o.Region < 0 {
+		return fmt.Errorf("bad region value %d", o.Region)
+	}
+	if _, ok := searchFamilyFilters[o.Family]; !ok {
+		return fmt.Errorf("bad family value %q, expected none, moderate or strict", o.Family)
+	}
+	return nil
+}
+
+// withDefaults подставляет незаданные параметры из config.yaml
+func (o SearchOptions) withDefaults() SearchOptions {
+	d := currentConfig().yandexDefaults()
+	if o.Pages == 0 {
+		o.Pages = d.Pages
+	}
+	if o.Hosts == 0 {
+		o.Hosts = d.Hosts
+	}
+	if o.Region == 0 {
+		o.Region = d.Region
+	}
+	if o.Lang == "" {
+		o.Lang = d.Lang
+	}
+	if o.Family == "" {
+		o.Family = d.Family
+	}
+	return o
+}
+
+var searchProviders = map[string]SearchProvider{
+	"yandex":     yandexProvider{},
+	"bing":       bingProvider{},
+	"duckduckgo": duckDuckGoProvider{},
+	"searxng":    searxngProvider{},
+}
+
+// getSearchProvider возвращает провайдера по имени, пустое имя - провайдер из config.yaml
+func getSearchProvider(name string) (SearchProvider, error) {
+	if name == "" {
+		name = currentConfig().SearchProvider
+	}
+	p, ok := searchProviders[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown search provider %q, available: %s", name, strings.Join(searchProviderNames(), ", "))
+	}
+	return p, nil
+}
+
+func searchProviderNames() []string {
+	names := make([]string, 0, len(searchProviders))
+	for name := range searchProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// fetchSearchPage загружает страницу выдачи поисковика, отмена ctx прерывает загрузку
+func fetchSearchPage(ctx context.Context, pageURL string) (body []byte, err error) {
+	ctx, sp := startSpan(ctx, "fetchSearchPage", spanClient, "http.url", pageURL)
+	defer func() {
+		sp.set("response.bytes", len(body))
+		sp.fail(err)
+		sp.finish()
+	}()
+	timeOut := currentConfig().timeOutWork()
+	client := &http.Client{Transport: pooledTransport(), Timeout: timeOut}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", searchUserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	sp.set("http.status_code", resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("search page %s: unexpected status %s", pageURL, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+const searchUserAgent = "Mozilla/5.0 (Linux; Android 10) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0 Mobile Safari/537.36"
+
+type yandexProvider struct{}
+
+func (yandexProvider) Name() string     { return "yandex" }
+func (yandexProvider) Endpoint() string { return baseYandexURL }
+
+// Search загружает страницы выдачи, пока не наберется opts.Hosts сайтов, не кончатся результаты
+// или Яндекс не покажет капчу. Капча или неизвестная разметка на первой странице - ошибка *SearchError.
+func (p yandexProvider) Search(ctx context.Context, query string, opts SearchOptions) responseStruct {
+	opts = opts.withDefaults()
+	res := responseStruct{Kind: SerpResults, Items: make([]responseItem, 0)}
+	hosts := make(map[string]struct{})
+	position := 0 // места обычных результатов продолжаются на следующих страницах
+	for page := 0; page < opts.Pages; page++ {
+		body, err := fetchSearchPage(ctx, yandexSearchURL(query, page, opts))
+		if err != nil {
+			if page == 0 {
+				return responseStruct{Error: &SearchError{Provider: p.Name(), Kind: SerpFetchError, Page: page, Err: err}}
+			}
+			logWarn(ctx, "Загрузка выдачи остановлена", "provider", p.Name(), "page", page, "error", err)
+			break
+		}
+		_, sp := startSpan(ctx, "parseYandexResponse", spanInternal, "page", page, "bytes", len(body))
+		pageRes := parseYandexResponse(body)
+		sp.set("serp.kind", pageRes.Kind, "items", len(pageRes.Items))
+		sp.fail(pageRes.Error)
+		sp.finish()
+		if pageRes.Error == nil && pageRes.Kind != SerpResults && pageRes.Kind != SerpEmpty {
+			pageRes.Error = &SearchError{Provider: p.Name(), Kind: pageRes.Kind, Page: page,
+				Err:     fmt.Errorf("no search results in page"),
+				RawPage: dumpSerpPage(p.Name(), pageRes.Kind, page, body)}
+		}
+		if pageRes.Error != nil {
+			if page == 0 {
+				return pageRes
+			}
+			logWarn(ctx, "Загрузка выдачи остановлена", "provider", p.Name(), "page", page, "error", pageRes.Error)
+			break
+		}
+		if pageRes.Kind == SerpEmpty {
+			if page == 0 {
+				res.Kind = SerpEmpty
+			}
+			break
+		}
+		pagePosition := 0
+		for _, item := range pageRes.Items {
+			if item.Position > 0 {
+				item.Position += position
+				pagePosition = item.Position
+			}
+			res.Items = append(res.Items, item)
+			hosts[item.Host] = struct{}{}
+		}
+		if pagePosition > 0 {
+			position = pagePosition
+		}
+		if opts.Hosts > 0 && len(hosts) >= opts.Hosts {
+			break
+		}
+	}
+	return res
+}
+
+// searchOnePage загружает и разбирает единственную страницу выдачи
+func searchOnePage(ctx context.Context, provider, pageURL string, parse func([]byte) responseStruct) responseStruct {
+	body, err := fetchSearchPage(ctx, pageURL)
+	if err != nil {
+		return responseStruct{Error: &SearchError{Provider: provider, Kind: SerpFetchError, Err: err}}
+	}
+	res := parse(body)
+	if res.Error != nil {
+		res.Error = &SearchError{Provider: provider, Kind: SerpUnrecognized, Err: res.Error,
+			RawPage: dumpSerpPage(provider, SerpUnrecognized, 0, body)}
+	}
+	return res
+}
+
+type bingProvider struct{}
+
+func (bingProvider) Name() string     { return "bing" }
+func (bingProvider) Endpoint() string { return "https://www.bing.com/" }
+
+func (p bingProvider) Search(ctx context.Context, query string, _ SearchOptions) responseStruct {
+	return searchOnePage(ctx, p.Name(), baseBingURL+url.QueryEscape(query), parseBingResponse)
+}
+
+type duckDuckGoProvider struct{}
+
+func (duckDuckGoProvider) Name() string     { return "duckduckgo" }
+func (duckDuckGoProvider) Endpoint() string { return "https://html.duckduckgo.com/html/" }
+
+func (p duckDuckGoProvider) Search(ctx context.Context, query string, _ SearchOptions) responseStruct {
+	return searchOnePage(ctx, p.Name(), baseDuckDuckGoURL+url.QueryEscape(query), parseDuckDuckGoResponse)
+}
+
+type searxngProvider struct{}
+
+func (searxngProvider) Name() string     { return "searxng" }
+func (searxngProvider) Endpoint() string { return currentConfig().SearxngURL }
+
+func (p searxngProvider) Search(ctx context.Context, query string, _ SearchOptions) responseStruct {
+	u, err := url.Parse(currentConfig().SearxngURL)
+	if err != nil {
+		return responseStruct{Error: fmt.Errorf("bad SearxngURL: %v", err)}
+	}
+	q := u.Query()
+	q.Set("q", query)
+	q.Set("format", "json")
+	u.RawQuery = q.Encode()
+
+	return searchOnePage(ctx, p.Name(), u.String(), parseSearxngResponse)
+}
@@ -1,24 +1,23 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"sync/atomic"
+	"strconv"
 	"time"
-
-	"golang.org/x/net/context"
 )
 
 func searchSites(w http.ResponseWriter, r *http.Request) {
-	timeOutRequest := time.Millisecond * time.Duration(atomic.LoadUint64(&TimeOutWork))
+	timeOutRequest := currentConfig().timeOutWork()
 	start := time.Now()
-	ctx, cancel := context.WithTimeout(context.Background(), timeOutRequest)
+	ctx, cancel := context.WithTimeout(r.Context(), timeOutRequest)
 	defer cancel()
 	defer func() {
 		end := time.Now()
-		fmt.Println("Время выполнения запроса", end.Sub(start))
+		logInfo(r.Context(), "Время выполнения запроса", "handler", "/sites", "duration", end.Sub(start))
 	}()
 	if r.Method != http.MethodGet {
 		w.Header().Set("Allow", http.MethodGet)
@@ -26,51 +25,76 @@ func searchSites(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	search := r.URL.Query().Get("search")
-	if search == "" {
-		http.Error(w, http.StatusText(400), 400)
+	spec, err := searchSpec(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
 		return
 	}
 
-	var defaultTtransport http.RoundTripper = &http.Transport{Proxy: nil}
-	client := &http.Client{Transport: defaultTtransport}
-
-	resp, err := client.Get(baseYandexURL + search)
-
-	if err != nil {
-		http.Error(w, http.StatusText(500), 500)
+	s, err := executeCheck(ctx, spec, checkProgress{})
+	var se *SearchError
+	if errors.As(err, &se) {
+		writeSearchError(w, se)
 		return
 	}
-	defer resp.Body.Close()
-
-	body, err := ioutil.ReadAll(resp.Body)
-
 	if err != nil {
 		http.Error(w, http.StatusText(500), 500)
 		return
 	}
-
-	//func parseYandexResponse(response []byte) (res responseStruct)
-	res := parseYandexResponse(body)
-
-	if res.Error != nil {
-		http.Error(w, http.StatusText(500), 500)
+	if errors.Is(r.Context().Err(), context.Canceled) {
+		logInfo(r.Context(), "Клиент отключился, запрос прерван", "handler", "/sites")
 		return
 	}
+	if ctx.Err() != nil {
+		logWarn(r.Context(), "Истекло время выполнения запроса", "handler", "/sites", "timeout", timeOutRequest)
+	}
+	for host, data := range s {
+		logDebug(r.Context(), "Результат проверки сайта", "host", host, "responses", data.ResponseCount, "time_response", data.TimeResponse, "checked", data.Checked)
+	}
+	json.NewEncoder(w).Encode(s)
+}
 
-	s := make(map[string]ResponseData)
-
-	for _, item := range res.Items {
-		select {
-		case <-ctx.Done():
-			fmt.Println("Истекло время выполнения запроса (", timeOutRequest, ").")
-			json.NewEncoder(w).Encode(s)
-			return
-		default:
-			count, timeResponse := checkAvailability(item.Url)
-			s[item.Host] = ResponseData{count, timeResponse}
-			fmt.Println(item.Host, count, timeResponse)
+// searchSpec формирует задание на проверку из параметров запроса search, provider, group, workers, mode
+// и параметров выдачи pages, hosts, region, lang, family
+func searchSpec(r *http.Request) (checkSpec, error) {
+	q := r.URL.Query()
+	spec := checkSpec{Search: q.Get("search"), Provider: q.Get("provider"), Group: q.Get("group"), Mode: TransportMode(q.Get("mode"))}
+	if spec.Search == "" {
+		return spec, fmt.Errorf("search parameter is required")
+	}
+	workers, err := parseWorkers(q.Get("workers"))
+	if err != nil {
+		return spec, err
+	}
+	spec.Workers = workers
+	for name, dst := range map[string]*int{"pages": &spec.Pages, "hosts": &spec.Hosts, "region": &spec.Region} {
+		if v := q.Get(name); v != "" {
+			if *dst, err = strconv.Atoi(v); err != nil {
+				return spec, fmt.Errorf("bad %s value %q", name, v)
+			}
 		}
 	}
-	json.NewEncoder(w).Encode(s)
+	if v := q.Get("allblocks"); v != "" {
+		if spec.AllBlocks, err = strconv.ParseBool(v); err != nil {
+			return spec, fmt.Errorf("bad allblocks value %q", v)
+		}
+	}
+	spec.Lang = q.Get("lang")
+	spec.Family = q.Get("family")
+	return spec, spec.validate(currentConfig())
+}
+
+// parseWorkers разбирает параметр workers, значение ограничено CheckWorkers
+func parseWorkers(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("bad workers value %q", v)
+	}
+	if max := int(currentConfig().CheckWorkers); n > max {
+		n = max
+	}
+	return n, nil
 }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// коды завершения сервиса, 2 - ошибка в параметрах командной строки, как у пакета flag
+const (
+	exitOK           = 0 // остановлен сигналом, все проверки завершены
+	exitServeError   = 1 // не удалось запустить HTTP сервер
+	exitDrainTimeout = 3 // остановлен сигналом, часть проверок прервана по DrainTimeout или повторным сигналом
+)
+
+var draining int32 // 1 - получен сигнал остановки, новые проверки не принимаются
+
+func isDraining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+func init() {
+	registerReadinessCheck("draining", func() error {
+		if isDraining() {
+			return errShuttingDown
+		}
+		return nil
+	})
+}
+
+// waitOrCancel ждет wg до истечения ctx, затем вызывает cancel и дожидается прерванных задач
+func waitOrCancel(ctx context.Context, wg *sync.WaitGroup, cancel context.CancelFunc) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		cancel()
+		return nil
+	case <-ctx.Done():
+	}
+	cancel()
+	<-done
+	return ctx.Err()
+}
+
+// serve обслуживает запросы до SIGTERM или SIGINT, затем останавливает сервис:
+// readiness переключается в not ready, через ShutdownDelay сервер перестает принимать соединения,
+// выполняющиеся запросы, фоновые задания, мониторы и отслеживание мест получают DrainTimeout на завершение,
+// после чего база истории закрывается. Повторный сигнал завершает процесс сразу.
+func serve(srv *http.Server) int {
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	select {
+	case err := <-serveErr:
+		logError(context.Background(), "Ошибка HTTP сервера", "error", err)
+		closeHistory()
+		closeTracing()
+		return exitServeError
+	case sig := <-signals:
+		logInfo(context.Background(), "Получен сигнал, остановка сервиса", "signal", sig)
+	}
+	atomic.StoreInt32(&draining, 1)
+	go func() {
+		sig := <-signals
+		logWarn(context.Background(), "Повторный сигнал, немедленное завершение", "signal", sig)
+		os.Exit(exitDrainTimeout)
+	}()
+
+	cfg := currentConfig()
+	time.Sleep(time.Millisecond * time.Duration(cfg.ShutdownDelay))
+	drainTimeout := time.Millisecond * time.Duration(cfg.DrainTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var timedOut int32
+	drain := func(name string, shutdown func(context.Context) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := shutdown(ctx); err != nil {
+				atomic.StoreInt32(&timedOut, 1)
+				logWarn(context.Background(), "Работа не завершена за DrainTimeout и прервана", "part", name, "timeout", drainTimeout, "error", err)
+			}
+		}()
+	}
+	drain("HTTP запросы", func(ctx context.Context) error {
+		err := srv.Shutdown(ctx)
+		if errors.Is(err, context.DeadlineExceeded) {
+			srv.Close()
+		}
+		return err
+	})
+	drain("Фоновые задания", jobs.shutdown)
+	drain("Мониторы", monitors.shutdown)
+	drain("Отслеживание мест", ranks.shutdown)
+	wg.Wait()
+	closeHistory()
+	closeTracing()
+
+	if atomic.LoadInt32(&timedOut) == 1 {
+		return exitDrainTimeout
+	}
+	logInfo(context.Background(), "Сервис остановлен")
+	return exitOK
+}
+
+func closeHistory() {
+	if history == nil {
+		return
+	}
+	if err := history.close(); err != nil {
+		logError(context.Background(), "Ошибка закрытия базы истории", "error", err)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// streamEvent событие потоковой выдачи результатов /sites/stream
+type streamEvent struct {
+	Event   string         // sites, site, summary, error
+	Sites   []string       `json:",omitempty"` // sites: список проверяемых сайтов
+	Host    string         `json:",omitempty"` // site: сайт
+	Data    *ResponseData  `json:",omitempty"` // site: результат проверки сайта
+	Summary *streamSummary `json:",omitempty"` // summary: итог проверки
+	Error   string         `json:",omitempty"` // error: текст ошибки
+}
+
+type streamSummary struct {
+	Total    int           // количество сайтов
+	Checked  int           // количество проверенных сайтов
+	TimedOut bool          // проверка прервана по TimeOutWork
+	Duration time.Duration // время выполнения запроса
+}
+
+// streamWriter пишет события в формате Server-Sent Events или NDJSON
+type streamWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	ndjson  bool
+	started bool
+}
+
+func (s *streamWriter) start() {
+	if s.started {
+		return
+	}
+	s.started = true
+	if s.ndjson {
+		s.w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		s.w.Header().Set("Content-Type", "text/event-stream")
+		s.w.Header().Set("Cache-Control", "no-cache")
+	}
+	s.w.WriteHeader(200)
+}
+
+func (s *streamWriter) send(e streamEvent) {
+	s.start()
+	b, _ := json.Marshal(e)
+	if s.ndjson {
+		fmt.Fprintf(s.w, "%s\n", b)
+	} else {
+		fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", e.Event, b)
+	}
+	s.flusher.Flush()
+}
+
+// wantsNDJSON формат NDJSON выбирается параметром format=ndjson или заголовком Accept
+func wantsNDJSON(r *http.Request) bool {
+	if f := r.URL.Query().Get("format"); f != "" {
+		return f == "ndjson"
+	}
+	return strings.Contains(r.Header.Get("Accept"), "application/x-ndjson")
+}
+
+// searchSitesStream то же, что /sites, но результат по каждому сайту отправляется сразу после проверки
+func searchSitesStream(w http.ResponseWriter, r *http.Request) {
+	timeOutWork := currentConfig().timeOutWork()
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(r.Context(), timeOutWork)
+	defer cancel()
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, http.StatusText(405), 405)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", 500)
+		return
+	}
+	spec, err := searchSpec(r)
+	if err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+
+	stream := &streamWriter{w: w, flusher: flusher, ndjson: wantsNDJSON(r)}
+	s, err := executeCheck(ctx, spec, checkProgress{
+		Sites: func(items []responseItem) {
+			sites := make([]string, len(items))
+			for i, item := range items {
+				sites[i] = item.Host
+			}
+			stream.send(streamEvent{Event: "sites", Sites: sites})
+		},
+		Result: func(host string, data ResponseData) {
+			stream.send(streamEvent{Event: "site", Host: host, Data: &data})
+		},
+	})
+	if err != nil {
+		var se *SearchError
+		if !stream.started && errors.As(err, &se) {
+			writeSearchError(w, se)
+			return
+		}
+		if !stream.started {
+			http.Error(w, http.StatusText(500), 500)
+			return
+		}
+		stream.send(streamEvent{Event: "error", Error: err.Error()})
+		return
+	}
+
+	if r.Context().Err() != nil {
+		logInfo(r.Context(), "Клиент отключился, запрос прерван", "handler", "/sites/stream")
+		return
+	}
+	summary := &streamSummary{Total: len(s), TimedOut: ctx.Err() != nil, Duration: time.Since(start)}
+	for _, data := range s {
+		if data.Checked {
+			summary.Checked++
+		}
+	}
+	stream.send(streamEvent{Event: "summary", Summary: summary})
+	logInfo(r.Context(), "Время выполнения запроса", "handler", "/sites/stream", "duration", summary.Duration)
+}
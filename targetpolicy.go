@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// TargetPolicy ограничения адресов, к которым обращаются проверки сайтов.
+// Адреса проверяются при установке соединения, после разрешения имени, поэтому смена DNS записи
+// между проверкой и запросом (DNS rebinding) не обходит запрет.
+type TargetPolicy struct {
+	AllowPrivate bool     // разрешить частные, loopback, link-local и служебные адреса (по умолчанию запрещены)
+	AllowDomains []string // если задан, проверяются только эти домены и их поддомены
+	DenyDomains  []string // домены и их поддомены, которые не проверяются
+	AllowCIDRs   []string // сети, разрешенные несмотря на запрет частных адресов
+	DenyCIDRs    []string // сети, которые не проверяются, приоритетнее AllowCIDRs
+}
+
+// targetPolicy разобранная TargetPolicy
+type targetPolicy struct {
+	allowPrivate bool
+	allowDomains []string
+	denyDomains  []string
+	allowNets    []*net.IPNet
+	denyNets     []*net.IPNet
+}
+
+// privateNets частные, loopback, link-local (в том числе адреса метаданных облаков 169.254.169.254 и fd00:ec2::254),
+// CGNAT и служебные сети, запрещенные без AllowPrivate
+var privateNets = mustParseCIDRs(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+	"192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets, err := parseCIDRs(cidrs)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, s := range cidrs {
+		_, n, err := net.ParseCIDR(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("bad CIDR %q", s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func normalizeDomains(domains []string) ([]string, error) {
+	res := make([]string, 0, len(domains))
+	for _, d := range domains {
+		d = normalizeTargetHost(d)
+		if d == "" || strings.ContainsAny(d, "/:* ") {
+			return nil, fmt.Errorf("bad domain %q", d)
+		}
+		res = append(res, d)
+	}
+	return res, nil
+}
+
+func normalizeTargetHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+func (p TargetPolicy) compile() (*targetPolicy, error) {
+	c := &targetPolicy{allowPrivate: p.AllowPrivate}
+	var err error
+	if c.allowDomains, err = normalizeDomains(p.AllowDomains); err != nil {
+		return nil, fmt.Errorf("AllowDomains: %v", err)
+	}
+	if c.denyDomains, err = normalizeDomains(p.DenyDomains); err != nil {
+		return nil, fmt.Errorf("DenyDomains: %v", err)
+	}
+	if c.allowNets, err = parseCIDRs(p.AllowCIDRs); err != nil {
+		return nil, fmt.Errorf("AllowCIDRs: %v", err)
+	}
+	if c.denyNets, err = parseCIDRs(p.DenyCIDRs); err != nil {
+		return nil, fmt.Errorf("DenyCIDRs: %v", err)
+	}
+	return c, nil
+}
+
+func (p TargetPolicy) validate() error {
+	_, err := p.compile()
+	return err
+}
+
+var activeTargetPolicy atomic.Value // *targetPolicy
+
+func setTargetPolicy(p TargetPolicy) {
+	c, err := p.compile()
+	if err != nil { // конфигурация проверена в validate
+		return
+	}
+	activeTargetPolicy.Store(c)
+}
+
+func currentTargetPolicy() *targetPolicy {
+	if c, ok := activeTargetPolicy.Load().(*targetPolicy); ok {
+		return c
+	}
+	return &targetPolicy{}
+}
+
+// blockedTargetError запрос к адресу запрещен TargetPolicy
+type blockedTargetError struct {
+	target string
+	reason string
+}
+
+func (e *blockedTargetError) Error() string {
+	return fmt.Sprintf("target %s blocked by policy: %s", e.target, e.reason)
+}
+
+func matchDomain(host string, domains []string) bool {
+	for _, d := range domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHost проверяет имя хоста по спискам доменов, IP адрес в имени - по сетям
+func (p *targetPolicy) checkHost(host string) error {
+	host = normalizeTargetHost(host)
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		return p.checkIP(ip)
+	}
+	if matchDomain(host, p.denyDomains) {
+		return &blockedTargetError{host, "domain is in DenyDomains"}
+	}
+	if len(p.allowDomains) > 0 && !matchDomain(host, p.allowDomains) {
+		return &blockedTargetError{host, "domain is not in AllowDomains"}
+	}
+	return nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIP проверяет адрес, к которому устанавливается соединение
+func (p *targetPolicy) checkIP(ip net.IP) error {
+	switch {
+	case containsIP(p.denyNets, ip):
+		return &blockedTargetError{ip.String(), "address is in DenyCIDRs"}
+	case containsIP(p.allowNets, ip), p.allowPrivate:
+		return nil
+	case containsIP(privateNets, ip):
+		return &blockedTargetError{ip.String(), "private, loopback or link-local address"}
+	}
+	return nil
+}
+
+// checkURL проверяет хост адреса до отправки запросов
+func (p *targetPolicy) checkURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil // ошибку адреса вернет сам запрос
+	}
+	return p.checkHost(u.Hostname())
+}
+
+// guardDialControl проверяет адрес соединения после разрешения имени, непосредственно перед connect
+func guardDialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return &blockedTargetError{host, "not an IP address"}
+	}
+	return currentTargetPolicy().checkIP(ip)
+}
+
+// checkTargetRedirect не дает перенаправлению увести проверку на запрещенный домен
+func checkTargetRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	return currentTargetPolicy().checkHost(req.URL.Hostname())
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func compileTestPolicy(t *testing.T, p TargetPolicy) *targetPolicy {
+	t.Helper()
+	c, err := p.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestTargetPolicyCheckIP(t *testing.T) {
+	deny := TargetPolicy{}
+	allow := TargetPolicy{AllowPrivate: true}
+	lists := TargetPolicy{AllowCIDRs: []string{"10.1.0.0/16"}, DenyCIDRs: []string{"10.1.2.0/24", "203.0.113.0/24"}}
+	tests := []struct {
+		policy  TargetPolicy
+		ip      string
+		blocked bool
+	}{
+		{deny, "127.0.0.1", true},
+		{deny, "127.10.0.1", true},
+		{deny, "::1", true},
+		{deny, "169.254.169.254", true}, // метаданные облака
+		{deny, "fd00:ec2::254", true},
+		{deny, "fe80::1", true},
+		{deny, "10.0.0.1", true},
+		{deny, "172.16.5.4", true},
+		{deny, "172.31.255.255", true},
+		{deny, "192.168.1.1", true},
+		{deny, "100.64.0.1", true},
+		{deny, "0.0.0.0", true},
+		{deny, "::", true},
+		{deny, "::ffff:127.0.0.1", true}, // IPv4-mapped IPv6
+		{deny, "::ffff:169.254.169.254", true},
+		{deny, "::ffff:10.0.0.1", true},
+		{deny, "172.32.0.1", false},
+		{deny, "93.184.216.34", false},
+		{deny, "2606:2800:220:1::1", false},
+		{deny, "::ffff:93.184.216.34", false},
+
+		{allow, "127.0.0.1", false},
+		{allow, "::1", false},
+		{allow, "169.254.169.254", false},
+		{allow, "192.168.1.1", false},
+		{allow, "93.184.216.34", false},
+
+		{lists, "10.1.0.5", false},   // AllowCIDRs разрешает часть частных адресов
+		{lists, "10.1.2.5", true},    // DenyCIDRs приоритетнее AllowCIDRs
+		{lists, "10.2.0.1", true},    // остальные частные адреса запрещены
+		{lists, "203.0.113.7", true}, // DenyCIDRs запрещает и публичные адреса
+		{lists, "93.184.216.34", false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%+v/%s", tt.policy, tt.ip), func(t *testing.T) {
+			ip := net.ParseIP(tt.ip)
+			if ip == nil {
+				t.Fatalf("bad test IP %q", tt.ip)
+			}
+			err := compileTestPolicy(t, tt.policy).checkIP(ip)
+			var be *blockedTargetError
+			if (err != nil) != tt.blocked || (err != nil && !errors.As(err, &be)) {
+				t.Errorf("checkIP(%s) = %v, want blocked: %v", tt.ip, err, tt.blocked)
+			}
+		})
+	}
+}
+
+func TestTargetPolicyCheckHost(t *testing.T) {
+	p := compileTestPolicy(t, TargetPolicy{
+		AllowDomains: []string{"example.com", "Example.RU."},
+		DenyDomains:  []string{"admin.example.com"},
+	})
+	tests := []struct {
+		host    string
+		blocked bool
+	}{
+		{"example.com", false},
+		{"www.example.com", false},
+		{"EXAMPLE.COM.", false},
+		{"example.ru", false},
+		{"admin.example.com", true},
+		{"x.admin.example.com", true},
+		{"notexample.com", true}, // не поддомен example.com
+		{"example.org", true},
+		{"127.0.0.1", true}, // IP адрес в имени проверяется по сетям
+		{"[::1]", true},
+		{"93.184.216.34", false},
+	}
+	for _, tt := range tests {
+		if err := p.checkHost(tt.host); (err != nil) != tt.blocked {
+			t.Errorf("checkHost(%q) = %v, want blocked: %v", tt.host, err, tt.blocked)
+		}
+	}
+
+	for _, bad := range []TargetPolicy{
+		{AllowCIDRs: []string{"10.0.0.0"}},
+		{DenyCIDRs: []string{"bad"}},
+		{AllowDomains: []string{"http://example.com/"}},
+		{DenyDomains: []string{"*.example.com"}},
+	} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("%+v: expected validation error", bad)
+		}
+	}
+}
+
+func TestGuardDialControl(t *testing.T) {
+	setTargetPolicy(TargetPolicy{})
+	defer setTargetPolicy(TargetPolicy{})
+	tests := []struct {
+		address string
+		blocked bool
+	}{
+		{"127.0.0.1:80", true},
+		{"[::1]:443", true},
+		{"169.254.169.254:80", true},
+		{"10.0.0.1:80", true},
+		{"[::ffff:192.168.0.1]:80", true},
+		{"93.184.216.34:443", false},
+		{"[2606:2800:220:1::1]:443", false},
+	}
+	for _, tt := range tests {
+		if err := guardDialControl("tcp", tt.address, nil); (err != nil) != tt.blocked {
+			t.Errorf("guardDialControl(%s) = %v, want blocked: %v", tt.address, err, tt.blocked)
+		}
+	}
+	if err := guardDialControl("tcp", "no-port", nil); err == nil {
+		t.Error("expected error for address without port")
+	}
+}
+
+// перенаправление на частный адрес: IP адрес отклоняется проверкой перенаправления,
+// имя, разрешающееся в частный адрес, - при установке соединения
+func TestTargetPolicyRedirect(t *testing.T) {
+	// разрешен только адрес первого сервера; Linux принимает соединения на всю сеть 127.0.0.0/8
+	ln, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("127.0.0.2 is not available: %v", err)
+	}
+	setTargetPolicy(TargetPolicy{AllowCIDRs: []string{"127.0.0.2/32"}})
+	defer setTargetPolicy(TargetPolicy{})
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached the private target")
+	}))
+	defer target.Close()
+	_, port, _ := net.SplitHostPort(target.Listener.Addr().String())
+
+	var location string
+	srv := &httptest.Server{Listener: ln, Config: &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, location, http.StatusFound)
+	})}}
+	srv.Start()
+	defer srv.Close()
+
+	transport := newTransport(transportSettings{DialTimeout: time.Second}, false, true)
+	client := &http.Client{Transport: transport, CheckRedirect: checkTargetRedirect}
+	for _, tt := range []struct{ name, location string }{
+		{"redirect check", "http://127.0.0.1:" + port + "/"},
+		{"metadata redirect check", "http://169.254.169.254/latest/meta-data/"},
+		{"dial time", "http://localhost:" + port + "/"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			location = tt.location
+			resp, err := client.Get(srv.URL + "/")
+			if err == nil {
+				resp.Body.Close()
+				t.Fatalf("redirect to %s was followed", tt.location)
+			}
+			var be *blockedTargetError
+			if !errors.As(err, &be) {
+				t.Errorf("got %v, want *blockedTargetError", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceExporter куда выгружаются спаны трассировки
+type TraceExporter string
+
+const (
+	TraceOff  TraceExporter = ""     // трассировка выключена
+	TraceFile TraceExporter = "file" // JSON строки в формате OTLP в файл TraceFile, без коллектора
+	TraceOTLP TraceExporter = "otlp" // OTLP/HTTP JSON на TraceEndpoint, например http://127.0.0.1:4318/v1/traces
+)
+
+var traceExporters = map[TraceExporter]struct{}{TraceOff: {}, TraceFile: {}, TraceOTLP: {}}
+
+// traceSettings настройки трассировки из config.yaml
+type traceSettings struct {
+	Exporter TraceExporter
+	File     string
+	Endpoint string
+}
+
+func (s traceSettings) validate() error {
+	if _, ok := traceExporters[s.Exporter]; !ok {
+		return fmt.Errorf("bad TraceExporter value %q, expected file or otlp", s.Exporter)
+	}
+	switch {
+	case s.Exporter == TraceFile && s.File == "":
+		return fmt.Errorf("TraceFile is required for TraceExporter file")
+	case s.Exporter == TraceOTLP:
+		if err := validateHTTPURL(s.Endpoint); err != nil {
+			return fmt.Errorf("TraceEndpoint: %v", err)
+		}
+	}
+	return nil
+}
+
+// spanKind вид спана в OTLP
+type spanKind int
+
+const (
+	spanInternal spanKind = 1
+	spanServer   spanKind = 2
+	spanClient   spanKind = 3
+)
+
+type spanAttr struct {
+	key   string
+	value interface{}
+}
+
+// span интервал трассировки. Методы nil спана ничего не делают, поэтому при выключенной трассировке
+// код вызывает их без проверок.
+type span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	sampled  bool
+	name     string
+	kind     spanKind
+	start    time.Time
+	end      time.Time
+	attrs    []spanAttr
+	errMsg   string
+	mu       sync.Mutex
+}
+
+type spanKey struct{}
+
+func spanFromContext(ctx context.Context) *span {
+	if ctx == nil {
+		return nil
+	}
+	s, _ := ctx.Value(spanKey{}).(*span)
+	return s
+}
+
+// startSpan начинает спан, дочерний к спану из ctx; kv - атрибуты парами ключ-значение
+func startSpan(ctx context.Context, name string, kind spanKind, kv ...interface{}) (context.Context, *span) {
+	if !tracingEnabled() {
+		return ctx, nil
+	}
+	s := &span{name: name, kind: kind, start: time.Now(), sampled: true}
+	if parent := spanFromContext(ctx); parent != nil {
+		s.traceID, s.parentID, s.sampled = parent.traceID, parent.spanID, parent.sampled
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	s.set(kv...)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// set добавляет атрибуты парами ключ-значение
+func (s *span) set(kv ...interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(kv); i += 2 {
+		s.attrs = append(s.attrs, spanAttr{fmt.Sprint(kv[i]), kv[i+1]})
+	}
+}
+
+// fail отмечает спан как завершившийся ошибкой
+func (s *span) fail(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.errMsg = err.Error()
+	s.mu.Unlock()
+}
+
+// finish завершает спан и передает его на выгрузку
+func (s *span) finish() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.end = time.Now()
+	s.mu.Unlock()
+	if s.sampled {
+		exportSpan(s)
+	}
+}
+
+func (s *span) traceIDString() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
+
+// traceparentHeader заголовок W3C Trace Context
+const traceparentHeader = "traceparent"
+
+// injectTraceparent передает спан из ctx в заголовке traceparent исходящего запроса
+func injectTraceparent(ctx context.Context, h http.Header) {
+	s := spanFromContext(ctx)
+	if s == nil {
+		return
+	}
+	flags := "00"
+	if s.sampled {
+		flags = "01"
+	}
+	h.Set(traceparentHeader, "00-"+hex.EncodeToString(s.traceID[:])+"-"+hex.EncodeToString(s.spanID[:])+"-"+flags)
+}
+
+// extractTraceparent возвращает ctx с удаленным родительским спаном из заголовка traceparent,
+// некорректный заголовок игнорируется
+func extractTraceparent(ctx context.Context, h http.Header) context.Context {
+	parts := strings.Split(h.Get(traceparentHeader), "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return ctx
+	}
+	remote := &span{}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return ctx
+	}
+	if _, err := hex.Decode(remote.traceID[:], []byte(parts[1])); err != nil || remote.traceID == [16]byte{} {
+		return ctx
+	}
+	if _, err := hex.Decode(remote.spanID[:], []byte(parts[2])); err != nil || remote.spanID == [8]byte{} {
+		return ctx
+	}
+	remote.sampled = flags[0]&1 == 1
+	return context.WithValue(ctx, spanKey{}, remote)
+}
+
+// traceHandler спан входящего запроса, родитель - из заголовка traceparent
+func traceHandler(name string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, sp := startSpan(extractTraceparent(r.Context(), r.Header), r.Method+" "+name, spanServer,
+			"http.method", r.Method, "http.route", name, "http.target", r.URL.RequestURI(), "request_id", requestID(r.Context()))
+		if sp == nil {
+			h(w, r)
+			return
+		}
+		defer sp.finish()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, r.WithContext(ctx))
+		sp.set("http.status_code", rec.status)
+		if rec.status >= 500 {
+			sp.fail(fmt.Errorf("%s", http.StatusText(rec.status)))
+		}
+	}
+}
+
+// statusRecorder запоминает код ответа, Flush передается дальше для /sites/stream
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// spanExporter выгружает пачку спанов. Экспортеры свои, а не из go.opentelemetry.io/otel: модуль остается на Go 1.17,
+// последний поддерживающий ее SDK v1.10 не обновляется и добавляет gRPC и protobuf; формат OTLP JSON проверяется в tracing_test.go.
+type spanExporter interface {
+	export(batch []*span) error
+	close() error
+}
+
+// tracer текущий экспортер и очередь спанов. При изменении настроек очередь старого экспортера
+// выгружается и закрывается, спаны, не поместившиеся в очередь, отбрасываются.
+var tracer struct {
+	mu       sync.RWMutex
+	settings traceSettings
+	queue    chan *span
+	done     chan struct{}
+}
+
+const (
+	traceQueueSize     = 4096
+	traceBatchSize     = 256
+	traceFlushInterval = 2 * time.Second
+)
+
+func tracingEnabled() bool {
+	tracer.mu.RLock()
+	defer tracer.mu.RUnlock()
+	return tracer.queue != nil
+}
+
+func exportSpan(s *span) {
+	tracer.mu.RLock()
+	defer tracer.mu.RUnlock()
+	if tracer.queue == nil {
+		return
+	}
+	select {
+	case tracer.queue <- s:
+	default:
+	}
+}
+
+// setTraceSettings применяет настройки трассировки, при изменении пересоздает экспортер.
+// Экспортер заменяется под блокировкой, а очередь старого выгружается после ее снятия:
+// выгрузка в коллектор может занять до таймаута клиента, и startSpan не должен ее ждать.
+func setTraceSettings(s traceSettings) {
+	tracer.mu.Lock()
+	if tracer.settings == s && (tracer.queue != nil) == (s.Exporter != TraceOff) {
+		tracer.mu.Unlock()
+		return
+	}
+	oldQueue, oldDone := tracer.queue, tracer.done
+	tracer.queue, tracer.done = nil, nil
+	tracer.settings = s
+	if e := newSpanExporter(s); e != nil {
+		tracer.queue = make(chan *span, traceQueueSize)
+		tracer.done = make(chan struct{})
+		go batchSpans(e, tracer.queue, tracer.done)
+	}
+	tracer.mu.Unlock()
+	drainTracer(oldQueue, oldDone)
+}
+
+// newSpanExporter экспортер по настройкам s, nil - трассировка выключена
+func newSpanExporter(s traceSettings) spanExporter {
+	switch s.Exporter {
+	case TraceFile:
+		f, err := os.OpenFile(s.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			logError(context.Background(), "Ошибка открытия файла трассировки, трассировка выключена", "file", s.File, "error", err)
+			return nil
+		}
+		return &fileSpanExporter{f: f}
+	case TraceOTLP:
+		return &otlpSpanExporter{endpoint: s.Endpoint, client: &http.Client{Timeout: 10 * time.Second}}
+	}
+	return nil
+}
+
+// closeTracing выгружает накопленные спаны при остановке сервиса
+func closeTracing() {
+	tracer.mu.Lock()
+	queue, done := tracer.queue, tracer.done
+	tracer.queue, tracer.done = nil, nil
+	tracer.mu.Unlock()
+	drainTracer(queue, done)
+}
+
+// drainTracer закрывает очередь отключенного экспортера и ждет выгрузки оставшихся спанов.
+// Спаны отправляются в очередь под tracer.mu, поэтому после замены очереди в нее никто не пишет.
+func drainTracer(queue chan *span, done chan struct{}) {
+	if queue == nil {
+		return
+	}
+	close(queue)
+	<-done
+}
+
+func batchSpans(e spanExporter, queue chan *span, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+	batch := make([]*span, 0, traceBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.export(batch); err != nil {
+			logWarn(context.Background(), "Ошибка выгрузки трассировки", "spans", len(batch), "error", err)
+		}
+		batch = make([]*span, 0, traceBatchSize)
+	}
+	for {
+		select {
+		case s, ok := <-queue:
+			if !ok {
+				flush()
+				if err := e.close(); err != nil {
+					logWarn(context.Background(), "Ошибка закрытия экспортера трассировки", "error", err)
+				}
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) >= traceBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// fileSpanExporter пишет каждую пачку строкой OTLP JSON (как file exporter коллектора OpenTelemetry)
+type fileSpanExporter struct {
+	f *os.File
+}
+
+func (e *fileSpanExporter) export(batch []*span) error {
+	b, err := json.Marshal(otlpRequest(batch))
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(e.f)
+	w.Write(b)
+	w.WriteByte('\n')
+	return w.Flush()
+}
+
+func (e *fileSpanExporter) close() error {
+	return e.f.Close()
+}
+
+// otlpSpanExporter отправляет пачки в коллектор по OTLP/HTTP с JSON кодированием
+type otlpSpanExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+func (e *otlpSpanExporter) export(batch []*span) error {
+	b, err := json.Marshal(otlpRequest(batch))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("OTLP endpoint %s: unexpected status %s", e.endpoint, resp.Status)
+	}
+	return nil
+}
+
+func (e *otlpSpanExporter) close() error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+// структуры ExportTraceServiceRequest в JSON кодировке OTLP
+type otlpAttr struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              spanKind   `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"` // 2 - ошибка
+	Message string `json:"message,omitempty"`
+}
+
+func otlpValue(v interface{}) map[string]interface{} {
+	switch x := v.(type) {
+	case bool:
+		return map[string]interface{}{"boolValue": x}
+	case int:
+		return map[string]interface{}{"intValue": strconv.FormatInt(int64(x), 10)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(x, 10)}
+	case uint64:
+		return map[string]interface{}{"intValue": strconv.FormatUint(x, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": x}
+	case time.Duration:
+		return map[string]interface{}{"stringValue": x.String()}
+	case error:
+		return map[string]interface{}{"stringValue": x.Error()}
+	}
+	return map[string]interface{}{"stringValue": fmt.Sprint(v)}
+}
+
+func otlpRequest(batch []*span) interface{} {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		s.mu.Lock()
+		o := otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parentID != [8]byte{} {
+			o.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		for _, a := range s.attrs {
+			o.Attributes = append(o.Attributes, otlpAttr{Key: a.key, Value: otlpValue(a.value)})
+		}
+		if s.errMsg != "" {
+			o.Status = otlpStatus{Code: 2, Message: s.errMsg}
+		}
+		s.mu.Unlock()
+		spans = append(spans, o)
+	}
+	resource := map[string]interface{}{"attributes": []otlpAttr{
+		{Key: "service.name", Value: otlpValue("demo-service")},
+		{Key: "service.version", Value: otlpValue(version)},
+	}}
+	return map[string]interface{}{"resourceSpans": []interface{}{map[string]interface{}{
+		"resource":   resource,
+		"scopeSpans": []interface{}{map[string]interface{}{"scope": map[string]string{"name": "demo-service"}, "spans": spans}},
+	}}}
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var (
+	traceIDRe = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	spanIDRe  = regexp.MustCompile(`^[0-9a-f]{16}$`)
+)
+
+// testSpans корневой спан входящего запроса и дочерний спан с ошибкой
+func testSpans() []*span {
+	start := time.Unix(1700000000, 123456789)
+	root := &span{name: "GET /sites", kind: spanServer, start: start, end: start.Add(time.Second), sampled: true}
+	copy(root.traceID[:], "0123456789abcdef")
+	copy(root.spanID[:], "rootspan")
+	root.set("http.status_code", 200, "cached", true, "ratio", 1.5, "bytes", int64(42), "count", uint64(7), "elapsed", 3*time.Millisecond, "http.route", "/sites")
+	child := &span{name: "readUrl", kind: spanClient, start: start.Add(time.Millisecond), end: start.Add(2 * time.Millisecond), sampled: true}
+	child.traceID, child.parentID = root.traceID, root.spanID
+	copy(child.spanID[:], "childspn")
+	child.fail(errors.New("connection refused"))
+	return []*span{root, child}
+}
+
+// otlpJSON пачка в JSON кодировке OTLP, разобранная без знания структур экспортера
+func otlpJSON(t *testing.T, data []byte) map[string]interface{} {
+	t.Helper()
+	var req map[string]interface{}
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatalf("bad JSON %s: %v", data, err)
+	}
+	return req
+}
+
+func field(t *testing.T, v interface{}, path ...interface{}) interface{} {
+	t.Helper()
+	for _, p := range path {
+		switch key := p.(type) {
+		case string:
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				t.Fatalf("%v: not an object at %q", path, key)
+			}
+			v = m[key]
+		case int:
+			a, ok := v.([]interface{})
+			if !ok || key >= len(a) {
+				t.Fatalf("%v: no element %d", path, key)
+			}
+			v = a[key]
+		}
+	}
+	return v
+}
+
+// attrs значения атрибутов по ключам: {"stringValue": ...} и т.п.
+func attrs(t *testing.T, list interface{}) map[string]map[string]interface{} {
+	t.Helper()
+	res := make(map[string]map[string]interface{})
+	items, _ := list.([]interface{})
+	for _, item := range items {
+		key, _ := field(t, item, "key").(string)
+		value, _ := field(t, item, "value").(map[string]interface{})
+		res[key] = value
+	}
+	return res
+}
+
+// формат ExportTraceServiceRequest по спецификации OTLP/JSON: имена полей lowerCamelCase, traceId и spanId - hex,
+// 64-битные целые и время - десятичные строки, kind и status.code - числа
+func TestOTLPRequestFormat(t *testing.T) {
+	data, err := json.Marshal(otlpRequest(testSpans()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := otlpJSON(t, data)
+
+	resource := attrs(t, field(t, req, "resourceSpans", 0, "resource", "attributes"))
+	if got := resource["service.name"]["stringValue"]; got != "demo-service" {
+		t.Errorf("service.name = %v", got)
+	}
+	if _, ok := resource["service.version"]["stringValue"]; !ok {
+		t.Error("no service.version resource attribute")
+	}
+	if got := field(t, req, "resourceSpans", 0, "scopeSpans", 0, "scope", "name"); got != "demo-service" {
+		t.Errorf("scope name = %v", got)
+	}
+
+	spans, _ := field(t, req, "resourceSpans", 0, "scopeSpans", 0, "spans").([]interface{})
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	root, child := spans[0], spans[1]
+	for _, sp := range spans {
+		if id, _ := field(t, sp, "traceId").(string); !traceIDRe.MatchString(id) {
+			t.Errorf("traceId %q is not 32 hex digits", id)
+		}
+		if id, _ := field(t, sp, "spanId").(string); !spanIDRe.MatchString(id) {
+			t.Errorf("spanId %q is not 16 hex digits", id)
+		}
+	}
+	if field(t, root, "traceId") != field(t, child, "traceId") || field(t, child, "parentSpanId") != field(t, root, "spanId") {
+		t.Error("child span is not linked to root")
+	}
+	if _, ok := root.(map[string]interface{})["parentSpanId"]; ok {
+		t.Error("root span must not have parentSpanId")
+	}
+	if field(t, root, "name") != "GET /sites" || field(t, root, "kind") != float64(spanServer) || field(t, child, "kind") != float64(spanClient) {
+		t.Errorf("bad name or kind: %v %v %v", field(t, root, "name"), field(t, root, "kind"), field(t, child, "kind"))
+	}
+	if got := field(t, root, "startTimeUnixNano"); got != "1700000000123456789" {
+		t.Errorf("startTimeUnixNano = %#v, want decimal string", got)
+	}
+	if got := field(t, root, "endTimeUnixNano"); got != strconv.FormatInt(time.Unix(1700000001, 123456789).UnixNano(), 10) {
+		t.Errorf("endTimeUnixNano = %#v", got)
+	}
+
+	a := attrs(t, field(t, root, "attributes"))
+	want := map[string]map[string]interface{}{
+		"http.status_code": {"intValue": "200"},
+		"cached":           {"boolValue": true},
+		"ratio":            {"doubleValue": 1.5},
+		"bytes":            {"intValue": "42"},
+		"count":            {"intValue": "7"},
+		"elapsed":          {"stringValue": "3ms"},
+		"http.route":       {"stringValue": "/sites"},
+	}
+	for key, value := range want {
+		got := a[key]
+		if len(got) != 1 {
+			t.Errorf("attribute %s = %v, want %v", key, got, value)
+			continue
+		}
+		for k, v := range value {
+			if got[k] != v {
+				t.Errorf("attribute %s = %v, want %v", key, got, value)
+			}
+		}
+	}
+
+	if status, _ := field(t, root, "status").(map[string]interface{}); len(status) != 0 {
+		t.Errorf("successful span status = %v, want unset", status)
+	}
+	if field(t, child, "status", "code") != float64(2) || field(t, child, "status", "message") != "connection refused" {
+		t.Errorf("failed span status = %v, want code 2 (STATUS_CODE_ERROR)", field(t, child, "status"))
+	}
+}
+
+func TestOTLPSpanExporter(t *testing.T) {
+	var got *http.Request
+	var body []byte
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	e := &otlpSpanExporter{endpoint: srv.URL + "/v1/traces", client: srv.Client()}
+	if err := e.export(testSpans()); err != nil {
+		t.Fatal(err)
+	}
+	if got.Method != http.MethodPost || got.URL.Path != "/v1/traces" || got.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("got %s %s with Content-Type %q, want POST /v1/traces application/json", got.Method, got.URL.Path, got.Header.Get("Content-Type"))
+	}
+	if spans, _ := field(t, otlpJSON(t, body), "resourceSpans", 0, "scopeSpans", 0, "spans").([]interface{}); len(spans) != 2 {
+		t.Errorf("got %d spans, want 2", len(spans))
+	}
+
+	status = http.StatusBadRequest
+	if err := e.export(testSpans()); err == nil {
+		t.Error("expected error for status 400")
+	}
+}
+
+func TestFileSpanExporter(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "traces.jsonl")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &fileSpanExporter{f: f}
+	spans := testSpans()
+	if err := e.export(spans[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.export(spans[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err = os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines := 0
+	for sc := bufio.NewScanner(f); sc.Scan(); lines++ {
+		if spans, _ := field(t, otlpJSON(t, sc.Bytes()), "resourceSpans", 0, "scopeSpans", 0, "spans").([]interface{}); len(spans) != 1 {
+			t.Errorf("line %d: got %d spans, want 1", lines, len(spans))
+		}
+	}
+	if lines != 2 {
+		t.Errorf("got %d lines, want one per batch", lines)
+	}
+}
+
+func TestTraceparent(t *testing.T) {
+	h := http.Header{}
+	h.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := extractTraceparent(context.Background(), h)
+	remote := spanFromContext(ctx)
+	if remote == nil || remote.traceIDString() != "4bf92f3577b34da6a3ce929d0e0e4736" || !remote.sampled {
+		t.Fatalf("bad remote span %+v", remote)
+	}
+	out := http.Header{}
+	injectTraceparent(ctx, out)
+	if got := out.Get(traceparentHeader); got != h.Get(traceparentHeader) {
+		t.Errorf("got traceparent %q, want %q", got, h.Get(traceparentHeader))
+	}
+
+	for _, bad := range []string{"", "garbage", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"} {
+		h.Set(traceparentHeader, bad)
+		if spanFromContext(extractTraceparent(context.Background(), h)) != nil {
+			t.Errorf("traceparent %q accepted", bad)
+		}
+	}
+}
+
+// смена настроек ждет выгрузки старой очереди, но не блокирует новые спаны
+func TestSetTraceSettingsDoesNotBlockSpans(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer closeTracing()
+
+	setTraceSettings(traceSettings{Exporter: TraceOTLP, Endpoint: srv.URL})
+	_, sp := startSpan(context.Background(), "test", spanInternal)
+	if sp == nil {
+		t.Fatal("tracing is not enabled")
+	}
+	sp.finish()
+
+	switched := make(chan struct{})
+	go func() {
+		// старый экспортер выгружает спан в коллектор, который не отвечает до release
+		setTraceSettings(traceSettings{Exporter: TraceFile, File: filepath.Join(t.TempDir(), "traces.jsonl")})
+		close(switched)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-switched:
+		t.Fatal("old exporter was not drained")
+	default:
+	}
+
+	started := make(chan *span)
+	go func() {
+		_, sp := startSpan(context.Background(), "during flush", spanInternal)
+		started <- sp
+	}()
+	select {
+	case sp := <-started:
+		if sp == nil {
+			t.Error("new exporter is not active during the old flush")
+		}
+		sp.finish()
+	case <-time.After(time.Second):
+		t.Error("startSpan is blocked by the old exporter flush")
+	}
+	close(release)
+	<-switched
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TransportMode режим соединений при проверке сайтов
+type TransportMode string
+
+const (
+	TransportCold TransportMode = "cold" // новое соединение на каждый запрос, в замер входит полное рукопожатие
+	TransportWarm TransportMode = "warm" // соединения переиспользуются (keep-alive), замер установившегося режима
+)
+
+var transportModes = map[TransportMode]struct{}{"": {}, TransportCold: {}, TransportWarm: {}}
+
+func (m TransportMode) validate() error {
+	if _, ok := transportModes[m]; !ok {
+		return fmt.Errorf("bad mode value %q, expected cold or warm", m)
+	}
+	return nil
+}
+
+// transportSettings настройки общего транспорта из config.yaml
+type transportSettings struct {
+	Mode                TransportMode // режим проверки сайтов по умолчанию
+	DialTimeout         time.Duration // таймаут установки соединения и TLS рукопожатия
+	MaxConnsPerHost     int           // максимум соединений к одному хосту, 0 - без ограничения
+	MaxIdleConnsPerHost int           // максимум простаивающих соединений к одному хосту в режиме warm
+	IdleConnTimeout     time.Duration // время жизни простаивающего соединения
+	HTTP2               bool          // разрешить HTTP/2
+}
+
+// общие транспорты: cold для проверок без переиспользования соединений, warm для проверок с keep-alive,
+// pooled для загрузки страниц выдачи и ClientSearchPoint. Соединения cold и warm проверяются по TargetPolicy.
+// При изменении настроек транспорты пересоздаются, простаивающие соединения старых закрываются.
+var transports struct {
+	mu       sync.Mutex
+	settings transportSettings
+	cold     *http.Transport
+	warm     *http.Transport
+	pooled   *http.Transport
+}
+
+// newTransport транспорт с настройками s, guarded - адреса соединений проверяются по TargetPolicy
+func newTransport(s transportSettings, keepAlive, guarded bool) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   s.DialTimeout,
+		KeepAlive: 30 * time.Second}
+	if guarded {
+		dialer.Control = guardDialControl
+	}
+	t := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: s.DialTimeout,
+		DisableKeepAlives:   !keepAlive,
+		MaxConnsPerHost:     s.MaxConnsPerHost,
+		MaxIdleConnsPerHost: s.MaxIdleConnsPerHost,
+		IdleConnTimeout:     s.IdleConnTimeout,
+		ForceAttemptHTTP2:   s.HTTP2,
+	}
+	if !s.HTTP2 {
+		// непустая карта TLSNextProto отключает HTTP/2
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	return t
+}
+
+func setTransportSettings(s transportSettings) {
+	transports.mu.Lock()
+	defer transports.mu.Unlock()
+	if transports.cold != nil && transports.settings == s {
+		return
+	}
+	old := []*http.Transport{transports.cold, transports.warm, transports.pooled}
+	transports.settings = s
+	transports.cold = newTransport(s, false, true)
+	transports.warm = newTransport(s, true, true)
+	transports.pooled = newTransport(s, true, false)
+	for _, t := range old {
+		if t != nil {
+			t.CloseIdleConnections()
+		}
+	}
+}
+
+// probeTransport транспорт проверки сайтов в режиме mode, пустой режим - из config.yaml
+func probeTransport(mode TransportMode) (*http.Transport, TransportMode) {
+	transports.mu.Lock()
+	defer transports.mu.Unlock()
+	if mode == "" {
+		mode = transports.settings.Mode
+	}
+	if mode == TransportWarm {
+		return transports.warm, mode
+	}
+	return transports.cold, TransportCold
+}
+
+// pooledTransport транспорт с переиспользованием соединений для поисковиков и ClientSearchPoint
+func pooledTransport() *http.Transport {
+	transports.mu.Lock()
+	defer transports.mu.Unlock()
+	return transports.pooled
+}
@@ -5,19 +5,74 @@ import "time"
 type ResponseData struct {
 	ResponseCount uint64
 	TimeResponse  time.Duration
+	Latency       PhaseLatency            // разбивка по фазам успешных запросов
+	Outcomes      map[ProbeOutcome]uint64 // количество запросов по классам результата
+	Error         string                  // первая ошибка запроса к сайту
+	Checked       bool                    // false - сайт не успели проверить до истечения TimeOutWork, все запросы отменены
+	Serp          []SerpItem              `json:",omitempty"` // результаты выдачи, относящиеся к сайту
+	Mode          TransportMode           `json:",omitempty"` // режим соединений: cold или warm
+	ReusedConns   uint64                  `json:",omitempty"` // запросов по ранее установленному соединению
+}
+
+// total количество запросов к сайту, включая отмененные
+func (d ResponseData) total() uint64 {
+	var n uint64
+	for _, c := range d.Outcomes {
+		n += c
+	}
+	return n
 }
 
 type ClientData struct {
-	Title string
-	Data  map[string]ResponseData
+	Title     string
+	Data      map[string]ResponseData
+	StreamURL string // адрес /sites/stream, если результаты выводятся по мере поступления
 }
 
 type responseStruct struct {
 	Error error
+	Kind  SerpKind // результат разбора страницы выдачи
 	Items []responseItem
 }
 
 type responseItem struct {
-	Host string
-	Url  string
+	Host     string
+	Url      string
+	Position int       // место среди обычных результатов, 0 - не обычный результат
+	Title    string    // заголовок
+	Snippet  string    // текст сниппета
+	Turbo    bool      // ссылка вела на турбо-страницу
+	Type     SerpBlock // вид блока выдачи
+}
+
+// SerpBlock вид блока выдачи
+type SerpBlock string
+
+const (
+	SerpOrganic SerpBlock = "organic"
+	SerpVideo   SerpBlock = "video"
+	SerpImages  SerpBlock = "images"
+	SerpWizard  SerpBlock = "wizard"
+	SerpAd      SerpBlock = "ad"
+)
+
+// SerpItem результат выдачи в ответе /sites
+type SerpItem struct {
+	Url      string
+	Position int `json:",omitempty"`
+	Title    string
+	Snippet  string `json:",omitempty"`
+	Turbo    bool   `json:",omitempty"`
+	Type     SerpBlock
+}
+
+func (item responseItem) serpItem() SerpItem {
+	return SerpItem{
+		Url:      item.Url,
+		Position: item.Position,
+		Title:    item.Title,
+		Snippet:  item.Snippet,
+		Turbo:    item.Turbo,
+		Type:     item.Type,
+	}
 }